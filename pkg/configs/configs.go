@@ -2,8 +2,11 @@
 package configs
 
 import (
+	"crypto/tls"
+	"fmt"
 	"time"
 
+	"github.com/gin-contrib/cors"
 	govcfg "github.com/metal-toolbox/governor-api/pkg/configs"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -14,24 +17,122 @@ const (
 	DefaultIAMRuntimeTimeoutSeconds = 15
 	// DefaultNATSBucketTTL is the default time-to-live duration for NATS Key-Value bucket entries
 	DefaultNATSBucketTTL = 5 * time.Minute
+	// DefaultHistoryFileTTL is the default time-to-live duration for the history file entries
+	DefaultHistoryFileTTL = 10 * time.Minute
+	// DefaultCORSMaxAge is the default duration the CORS preflight responses are cached
+	DefaultCORSMaxAge = 12 * time.Hour
 )
 
 // AppConfig holds the application configuration
 var AppConfig struct {
 	govcfg.Configs `mapstructure:",squash"`
 
-	DryRun   bool `mapstructure:"dryrun"`
-	Audit    Audit
-	Tracing  Tracing
-	Logging  Logging
-	Governor Governor
-	Server   Server
-	NATS     NATSConfig
+	DryRun      bool `mapstructure:"dryrun"`
+	Audit       Audit
+	Tracing     Tracing
+	Logging     Logging
+	Governor    Governor
+	Server      Server
+	NATS        NATSConfig
+	EventRouter EventRouter
 }
 
 // Server holds server configuration
 type Server struct {
-	Listen string `mapstructure:"listen"`
+	Listen      string     `mapstructure:"listen"`
+	AdminListen string     `mapstructure:"admin-listen"`
+	Auth        ServerAuth `mapstructure:"auth"`
+	CORS        ServerCORS `mapstructure:"cors"`
+	TLS         ServerTLS  `mapstructure:"tls"`
+}
+
+// ServerTLS holds the TLS configuration of the server listeners, TLS is
+// disabled when no certificate is set
+type ServerTLS struct {
+	CertFile     string `mapstructure:"cert-file"`
+	KeyFile      string `mapstructure:"key-file"`
+	ClientCAFile string `mapstructure:"client-ca-file"`
+	MinVersion   string `mapstructure:"min-version"`
+}
+
+// Enabled reports whether TLS is configured
+func (t ServerTLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// Validate checks that the certificate and key files are set together and
+// that the minimum TLS version is supported
+func (t ServerTLS) Validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return ErrMissingTLSKeyPair
+	}
+
+	_, err := t.MinTLSVersion()
+
+	return err
+}
+
+// MinTLSVersion returns the minimum TLS version, TLS 1.2 when not set
+func (t ServerTLS) MinTLSVersion() (uint16, error) {
+	switch t.MinVersion {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedTLSVersion, t.MinVersion)
+	}
+}
+
+// ServerAuth holds the JWT authentication configuration of the server,
+// authentication is disabled when no issuer is set
+type ServerAuth struct {
+	Issuer         string   `mapstructure:"issuer"`
+	JWKSURL        string   `mapstructure:"jwks-url"`
+	Audiences      []string `mapstructure:"audiences"`
+	Scopes         []string `mapstructure:"scopes"`
+	AdminScopes    []string `mapstructure:"admin-scopes"`
+	ProtectHealth  bool     `mapstructure:"protect-health"`
+	ProtectMetrics bool     `mapstructure:"protect-metrics"`
+}
+
+// ServerCORS holds the CORS policy of the server, all origins are allowed when
+// no origin is set. Credentials are only allowed when AllowCredentials is set.
+type ServerCORS struct {
+	AllowOrigins     []string      `mapstructure:"allow-origins"`
+	AllowMethods     []string      `mapstructure:"allow-methods"`
+	AllowHeaders     []string      `mapstructure:"allow-headers"`
+	ExposeHeaders    []string      `mapstructure:"expose-headers"`
+	AllowCredentials bool          `mapstructure:"allow-credentials"`
+	MaxAge           time.Duration `mapstructure:"max-age"`
+}
+
+// Config returns the CORS configuration, the unset methods, headers and max
+// age get their defaults
+func (c ServerCORS) Config() cors.Config {
+	cfg := cors.Config{
+		AllowOrigins:     c.AllowOrigins,
+		AllowAllOrigins:  len(c.AllowOrigins) == 0,
+		AllowMethods:     c.AllowMethods,
+		AllowHeaders:     c.AllowHeaders,
+		ExposeHeaders:    c.ExposeHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}
+
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = DefaultCORSMaxAge
+	}
+
+	if len(cfg.AllowMethods) == 0 {
+		cfg.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
+	}
+
+	if len(cfg.AllowHeaders) == 0 {
+		cfg.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	}
+
+	return cfg
 }
 
 // MustServerFlags registers Server related flags and binds them to viper
@@ -39,6 +140,28 @@ type Server struct {
 func MustServerFlags(v *viper.Viper, flags *pflag.FlagSet) {
 	flags.String("listen", "0.0.0.0:8000", "address and port to listen on")
 	viperBindFlag(v, "server.listen", flags.Lookup("listen"))
+	flags.String("admin-listen", "", "address and port of the admin listener, admin routes are served on --listen when empty")
+	viperBindFlag(v, "server.admin-listen", flags.Lookup("admin-listen"))
+	flags.String("auth-issuer", "", "issuer of the JWT bearer tokens required on custom and admin routes, disables authentication when empty")
+	viperBindFlag(v, "server.auth.issuer", flags.Lookup("auth-issuer"))
+	flags.String("auth-jwks-url", "", "URL of the JWKS used to verify the JWT bearer tokens, discovered from the issuer when empty")
+	viperBindFlag(v, "server.auth.jwks-url", flags.Lookup("auth-jwks-url"))
+	flags.StringSlice("auth-audiences", []string{}, "accepted audiences of the JWT bearer tokens")
+	viperBindFlag(v, "server.auth.audiences", flags.Lookup("auth-audiences"))
+	flags.StringSlice("auth-scopes", []string{}, "scopes required on the JWT bearer tokens")
+	viperBindFlag(v, "server.auth.scopes", flags.Lookup("auth-scopes"))
+	flags.StringSlice("auth-admin-scopes", []string{}, "additional scopes required on the JWT bearer tokens for admin routes")
+	viperBindFlag(v, "server.auth.admin-scopes", flags.Lookup("auth-admin-scopes"))
+	flags.StringSlice("cors-allow-origins", []string{}, "origins allowed by the CORS policy, all origins are allowed when empty")
+	viperBindFlag(v, "server.cors.allow-origins", flags.Lookup("cors-allow-origins"))
+	flags.String("tls-cert-file", "", "TLS certificate file of the listeners, TLS is disabled when empty")
+	viperBindFlag(v, "server.tls.cert-file", flags.Lookup("tls-cert-file"))
+	flags.String("tls-key-file", "", "TLS key file of the listeners")
+	viperBindFlag(v, "server.tls.key-file", flags.Lookup("tls-key-file"))
+	flags.String("tls-client-ca-file", "", "CA file verifying client certificates, enables mTLS")
+	viperBindFlag(v, "server.tls.client-ca-file", flags.Lookup("tls-client-ca-file"))
+	flags.String("tls-min-version", "1.2", "minimum TLS version of the listeners, 1.2 or 1.3")
+	viperBindFlag(v, "server.tls.min-version", flags.Lookup("tls-min-version"))
 }
 
 // viperBindFlag provides a wrapper around the viper bindings that handles error checks
@@ -0,0 +1,61 @@
+package configs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerCORSConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         ServerCORS
+		allOrigins  bool
+		credentials bool
+		maxAge      time.Duration
+		headers     []string
+	}{
+		{
+			name:       "defaults",
+			allOrigins: true,
+			maxAge:     DefaultCORSMaxAge,
+			headers:    []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		},
+		{
+			name:        "explicit fields without origins",
+			cfg:         ServerCORS{AllowCredentials: true, MaxAge: time.Minute, AllowHeaders: []string{"X-Custom"}},
+			allOrigins:  true,
+			credentials: true,
+			maxAge:      time.Minute,
+			headers:     []string{"X-Custom"},
+		},
+		{
+			name:    "origins without credentials",
+			cfg:     ServerCORS{AllowOrigins: []string{"https://ui.example.com"}},
+			maxAge:  DefaultCORSMaxAge,
+			headers: []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		},
+		{
+			name:        "origins with credentials",
+			cfg:         ServerCORS{AllowOrigins: []string{"https://ui.example.com"}, AllowCredentials: true},
+			credentials: true,
+			maxAge:      DefaultCORSMaxAge,
+			headers:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg.Config()
+
+			assert.Equal(t, tt.allOrigins, cfg.AllowAllOrigins)
+			assert.Equal(t, tt.cfg.AllowOrigins, cfg.AllowOrigins)
+			assert.Equal(t, tt.credentials, cfg.AllowCredentials)
+			assert.Equal(t, tt.maxAge, cfg.MaxAge)
+			assert.Equal(t, tt.headers, cfg.AllowHeaders)
+			assert.NotEmpty(t, cfg.AllowMethods)
+			assert.NoError(t, cfg.Validate())
+		})
+	}
+}
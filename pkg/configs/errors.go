@@ -2,5 +2,17 @@ package configs
 
 import "errors"
 
-// ErrUnsupportedTracingProvider is returned when an unsupported tracing provider is specified.
-var ErrUnsupportedTracingProvider = errors.New("unsupported tracing provider")
+var (
+	// ErrUnsupportedTracingProvider is returned when an unsupported tracing provider is specified.
+	ErrUnsupportedTracingProvider = errors.New("unsupported tracing provider")
+	// ErrMissingNATSBucketName is returned when a NATS bucket is configured without a name.
+	ErrMissingNATSBucketName = errors.New("missing NATS bucket name")
+	// ErrUnsupportedNATSBucketStorage is returned when an unsupported NATS bucket storage type is specified.
+	ErrUnsupportedNATSBucketStorage = errors.New("unsupported NATS bucket storage")
+	// ErrUnsupportedTLSVersion is returned when an unsupported minimum TLS version is specified.
+	ErrUnsupportedTLSVersion = errors.New("unsupported TLS version")
+	// ErrMissingTLSKeyPair is returned when only one of the TLS certificate and key files is specified.
+	ErrMissingTLSKeyPair = errors.New("TLS certificate and key files must be set together")
+	// ErrMultipleHistoryCaches is returned when both a NATS bucket and a history file are configured.
+	ErrMultipleHistoryCaches = errors.New("only one of a NATS bucket and a history file can be used as the history cache")
+)
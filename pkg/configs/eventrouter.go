@@ -0,0 +1,50 @@
+package configs
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// EventRouter holds event router configuration
+type EventRouter struct {
+	Filter      string      `mapstructure:"filter"`
+	SkipRules   []SkipRule  `mapstructure:"skip-rules"`
+	HistoryFile HistoryFile `mapstructure:"history-file"`
+}
+
+// HistoryFile holds the configuration of the local file used as the
+// correlation ID history cache, see historycache.FileCache
+type HistoryFile struct {
+	Path            string        `mapstructure:"path"`
+	TTL             time.Duration `mapstructure:"ttl"`
+	SweepInterval   time.Duration `mapstructure:"sweep-interval"`
+	CompactInterval time.Duration `mapstructure:"compact-interval"`
+}
+
+// SkipRule holds the configuration of a correlation ID skip rule, see
+// eventrouter.SkipRule and server.SkipRulesFromConfig
+type SkipRule struct {
+	Name       string   `mapstructure:"name"`
+	Actions    []string `mapstructure:"actions"`
+	Subjects   []string `mapstructure:"subjects"`
+	ERDs       []string `mapstructure:"erds"`
+	Expression string   `mapstructure:"expression"`
+	Never      bool     `mapstructure:"never"`
+}
+
+// MustEventRouterFlags registers event router related flags and binds them to viper
+// Panics on error
+func MustEventRouterFlags(v *viper.Viper, flags *pflag.FlagSet) {
+	flags.String("event-filter", "", `expression selecting the events to process, e.g. 'action == "UPDATE" && group_id in ("a", "b")'`)
+	viperBindFlag(v, "eventrouter.filter", flags.Lookup("event-filter"))
+	flags.String("history-file-path", "", "local file used as the correlation ID history cache, an in-memory cache is used if empty")
+	viperBindFlag(v, "eventrouter.history-file.path", flags.Lookup("history-file-path"))
+	flags.Duration("history-file-ttl", DefaultHistoryFileTTL, "time the IDs are kept in the history file")
+	viperBindFlag(v, "eventrouter.history-file.ttl", flags.Lookup("history-file-ttl"))
+	flags.Duration("history-file-sweep-interval", 0, "how often expired IDs are deleted from the history file, defaults to half of the TTL")
+	viperBindFlag(v, "eventrouter.history-file.sweep-interval", flags.Lookup("history-file-sweep-interval"))
+	flags.Duration("history-file-compact-interval", 0, "how often the history file is compacted to give back the space of deleted IDs, 0 never compacts")
+	viperBindFlag(v, "eventrouter.history-file.compact-interval", flags.Lookup("history-file-compact-interval"))
+}
@@ -16,6 +16,9 @@ type Governor struct {
 	ExtensionID      string   `mapstructure:"extension-id"`
 	ERDsPath         string   `mapstructure:"erds-path"`
 	WorkloadIdentity bool     `mapstructure:"workload-identity"`
+	// WorkloadIdentitySubject is the subject of the workload identity used to
+	// authenticate with the Governor API
+	WorkloadIdentitySubject string `mapstructure:"workload-identity-subject"`
 }
 
 // MustGovernorFlags registers Governor related flags and binds them to viper
@@ -39,4 +42,23 @@ func MustGovernorFlags(v *viper.Viper, flags *pflag.FlagSet) {
 	viperBindFlag(v, "governor.erds-path", flags.Lookup("governor-erds-path"))
 	flags.Bool("governor-workload-identity", false, "use workload identity federation instead of client credentials for governor auth")
 	viperBindFlag(v, "governor.workload-identity", flags.Lookup("governor-workload-identity"))
+	flags.String("governor-workload-identity-subject", "", "subject of the workload identity, used to recognize the events caused by the extension")
+	viperBindFlag(v, "governor.workload-identity-subject", flags.Lookup("governor-workload-identity-subject"))
+}
+
+// SelfActorIDs returns the actor IDs of the identity the extension uses to
+// authenticate with the Governor API, i.e. the workload identity subject when
+// workload identity is enabled and the OAuth client ID otherwise. Events with
+// these actors were caused by the extension itself.
+func (g Governor) SelfActorIDs() []string {
+	id := g.ClientID
+	if g.WorkloadIdentity {
+		id = g.WorkloadIdentitySubject
+	}
+
+	if id == "" {
+		return nil
+	}
+
+	return []string{id}
 }
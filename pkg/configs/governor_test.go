@@ -0,0 +1,42 @@
+package configs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGovernorSelfActorIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Governor
+		want []string
+	}{
+		{
+			name: "client credentials",
+			cfg:  Governor{ClientID: "client-id", WorkloadIdentitySubject: "subject"},
+			want: []string{"client-id"},
+		},
+		{
+			name: "workload identity",
+			cfg:  Governor{ClientID: "client-id", WorkloadIdentity: true, WorkloadIdentitySubject: "subject"},
+			want: []string{"subject"},
+		},
+		{
+			name: "no client ID",
+			cfg:  Governor{WorkloadIdentitySubject: "subject"},
+			want: nil,
+		},
+		{
+			name: "workload identity without subject",
+			cfg:  Governor{ClientID: "client-id", WorkloadIdentity: true},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cfg.SelfActorIDs())
+		})
+	}
+}
@@ -1,7 +1,11 @@
 package configs
 
 import (
+	"fmt"
+	"time"
+
 	govcfg "github.com/metal-toolbox/governor-api/pkg/configs"
+	"github.com/nats-io/nats.go"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -9,8 +13,19 @@ import (
 // NATSConfig holds NATS configuration
 type NATSConfig struct {
 	govcfg.NATSConfig `mapstructure:",squash"`
-	QueueGroup        string `mapstructure:"queue-group"`
-	QueueSize         int    `mapstructure:"queue-size"`
+	QueueGroup        string     `mapstructure:"queue-group"`
+	QueueSize         int        `mapstructure:"queue-size"`
+	Bucket            NATSBucket `mapstructure:"bucket"`
+}
+
+// NATSBucket holds the configuration of the NATS Key-Value bucket used as the
+// correlation ID history cache
+type NATSBucket struct {
+	Name     string        `mapstructure:"name"`
+	TTL      time.Duration `mapstructure:"ttl"`
+	Replicas int           `mapstructure:"replicas"`
+	Storage  string        `mapstructure:"storage"`
+	MaxBytes int64         `mapstructure:"max-bytes"`
 }
 
 // MustNATSFlags registers NATS related flags and binds them to viper
@@ -21,4 +36,44 @@ func MustNATSFlags(v *viper.Viper, flags *pflag.FlagSet) {
 	viperBindFlag(v, "nats.queue-group", flags.Lookup("nats-queue-group"))
 	flags.Int("nats-queue-size", 3, "queue size for load balancing messages across NATS consumers") //nolint: mnd
 	viperBindFlag(v, "nats.queue-size", flags.Lookup("nats-queue-size"))
+	flags.String("nats-bucket-name", "", "NATS Key-Value bucket used as the correlation ID history cache, an in-memory cache is used if empty")
+	viperBindFlag(v, "nats.bucket.name", flags.Lookup("nats-bucket-name"))
+	flags.Duration("nats-bucket-ttl", DefaultNATSBucketTTL, "time-to-live of the NATS Key-Value bucket entries")
+	viperBindFlag(v, "nats.bucket.ttl", flags.Lookup("nats-bucket-ttl"))
+	flags.Int("nats-bucket-replicas", 1, "number of replicas of the NATS Key-Value bucket")
+	viperBindFlag(v, "nats.bucket.replicas", flags.Lookup("nats-bucket-replicas"))
+	flags.String("nats-bucket-storage", "file", "storage type of the NATS Key-Value bucket, one of file or memory")
+	viperBindFlag(v, "nats.bucket.storage", flags.Lookup("nats-bucket-storage"))
+	flags.Int64("nats-bucket-max-bytes", -1, "maximum size of the NATS Key-Value bucket in bytes, -1 for unlimited")
+	viperBindFlag(v, "nats.bucket.max-bytes", flags.Lookup("nats-bucket-max-bytes"))
+}
+
+// KeyValueConfig returns the NATS Key-Value configuration of the bucket, the
+// TTL defaults to DefaultNATSBucketTTL
+func (b NATSBucket) KeyValueConfig() (*nats.KeyValueConfig, error) {
+	if b.Name == "" {
+		return nil, ErrMissingNATSBucketName
+	}
+
+	cfg := &nats.KeyValueConfig{
+		Bucket:   b.Name,
+		TTL:      b.TTL,
+		Replicas: b.Replicas,
+		MaxBytes: b.MaxBytes,
+	}
+
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultNATSBucketTTL
+	}
+
+	switch b.Storage {
+	case "", "file":
+		cfg.Storage = nats.FileStorage
+	case "memory":
+		cfg.Storage = nats.MemoryStorage
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedNATSBucketStorage, b.Storage)
+	}
+
+	return cfg, nil
 }
@@ -0,0 +1,283 @@
+package eventrouter
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultBatchMaxSize is the default maximum number of events in a batch
+	DefaultBatchMaxSize = 10
+	// DefaultBatchMaxWait is the default maximum duration an event waits for
+	// its batch to be dispatched
+	DefaultBatchMaxWait = 1 * time.Second
+)
+
+// BatchHandler is a function for processing a batch of governor events, it
+// returns one error per event in the same order as the events, nil for the
+// events that were processed successfully
+type BatchHandler func(context.Context, []*govevents.Event) []error
+
+// Batcher is responsible for accumulating events and passing them to a batch
+// handler, either when the batch is full or when the oldest event in the batch
+// has waited for the max wait duration.
+type Batcher struct {
+	logger *zap.Logger
+	tracer trace.Tracer
+
+	handler BatchHandler
+	maxSize int
+	maxWait time.Duration
+
+	mu      sync.Mutex
+	current *batch
+}
+
+// batch is a batch of events waiting to be dispatched
+type batch struct {
+	items []*batchItem
+	timer *time.Timer
+	// due is closed once the oldest event waited for the max wait
+	due chan struct{}
+}
+
+type batchItem struct {
+	ctx    context.Context
+	event  *govevents.Event
+	result chan error
+}
+
+// BatcherOpt is a function type for configuring Batcher.
+type BatcherOpt func(*Batcher)
+
+// NewBatcher creates a new instance of Batcher for the batch handler with the
+// provided options.
+func NewBatcher(h BatchHandler, opts ...BatcherOpt) *Batcher {
+	b := &Batcher{
+		logger:  zap.NewNop(),
+		tracer:  noop.NewTracerProvider().Tracer("batcher"),
+		handler: h,
+		maxSize: DefaultBatchMaxSize,
+		maxWait: DefaultBatchMaxWait,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// BatcherWithMaxSize sets the maximum number of events in a batch.
+func BatcherWithMaxSize(n int) BatcherOpt {
+	return func(b *Batcher) {
+		b.maxSize = n
+	}
+}
+
+// BatcherWithMaxWait sets the maximum duration an event waits for its batch
+// to be dispatched.
+func BatcherWithMaxWait(d time.Duration) BatcherOpt {
+	return func(b *Batcher) {
+		b.maxWait = d
+	}
+}
+
+// BatcherWithLogger sets the logger for Batcher.
+func BatcherWithLogger(logger *zap.Logger) BatcherOpt {
+	return func(b *Batcher) {
+		b.logger = logger
+	}
+}
+
+// BatcherWithTracer sets the tracer for the spans of the batches.
+func BatcherWithTracer(tracer trace.Tracer) BatcherOpt {
+	return func(b *Batcher) {
+		b.tracer = tracer
+	}
+}
+
+// Handle is a Handler that adds the event to the current batch and waits for
+// the batch to be processed. It returns the error the batch handler reported
+// for this event, so failed events can be retried individually.
+//
+// When the context carries a Dispatcher, e.g. when the router is run by the
+// server, the event gives its worker back while it waits, so batches larger
+// than the number of workers fill up. The batch is dispatched by the event
+// that fills it, or by one of its events once the max wait is reached, on
+// that event's worker. An event whose context is done before its batch is
+// dispatched is removed from the batch.
+func (b *Batcher) Handle(ctx context.Context, event *govevents.Event) error {
+	item := &batchItem{ctx: ctx, event: event, result: make(chan error, 1)}
+
+	b.mu.Lock()
+
+	cur := b.current
+	if cur == nil {
+		cur = &batch{due: make(chan struct{})}
+		cur.timer = time.AfterFunc(b.maxWait, func() { close(cur.due) })
+		b.current = cur
+	}
+
+	cur.items = append(cur.items, item)
+
+	full := len(cur.items) >= b.maxSize
+	if full {
+		b.take(cur)
+	}
+
+	b.mu.Unlock()
+
+	if full {
+		b.dispatch(cur.items)
+		return <-item.result
+	}
+
+	reacquire := b.releaseWorker(ctx)
+	defer reacquire()
+
+	select {
+	case err := <-item.result:
+		return err
+	case <-cur.due:
+		// the first waiting event to notice dispatches the batch
+		b.mu.Lock()
+		taken := b.current == cur
+		if taken {
+			b.take(cur)
+		}
+		b.mu.Unlock()
+
+		if taken {
+			reacquire()
+			b.dispatch(cur.items)
+		}
+
+		return <-item.result
+	case <-ctx.Done():
+		b.remove(cur, item)
+		return ctx.Err()
+	}
+}
+
+// releaseWorker gives the worker of the event back to the dispatcher of the
+// context while the event waits, the returned function takes a worker again
+// and can be called more than once
+func (b *Batcher) releaseWorker(ctx context.Context) func() {
+	d := GetDispatcherFromContext(ctx)
+	if d == nil {
+		return func() {}
+	}
+
+	return sync.OnceFunc(d.ReleaseWorker())
+}
+
+// take makes the batch no longer the current one, it must be called with the
+// lock held
+func (b *Batcher) take(cur *batch) {
+	b.current = nil
+	cur.timer.Stop()
+}
+
+// remove removes the item from the batch if it was not dispatched yet
+func (b *Batcher) remove(cur *batch, item *batchItem) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.current != cur {
+		return
+	}
+
+	cur.items = slices.DeleteFunc(cur.items, func(i *batchItem) bool { return i == item })
+
+	if len(cur.items) == 0 {
+		b.take(cur)
+	}
+}
+
+func (b *Batcher) dispatch(items []*batchItem) {
+	events := make([]*govevents.Event, len(items))
+	links := make([]trace.Link, len(items))
+
+	for i, item := range items {
+		events[i] = item.event
+		links[i] = trace.LinkFromContext(item.ctx)
+	}
+
+	b.logger.Debug(
+		"dispatching event batch",
+		zap.Int("size", len(items)),
+		zap.String("component", "batcher"),
+	)
+
+	ctx, span := b.tracer.Start(b.batchContext(items), "process-batch",
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("batch.size", len(items))),
+	)
+	defer span.End()
+
+	errs := b.callHandler(ctx, events)
+
+	for i, item := range items {
+		if i < len(errs) {
+			item.result <- errs[i]
+			continue
+		}
+
+		item.result <- fmt.Errorf("%w: got %d results for %d events", ErrBatchResultMismatch, len(errs), len(items))
+	}
+}
+
+// batchContext returns the context of the batch handler. The batch outlives
+// the callers that gave up waiting, and none of its events' metadata applies
+// to the whole batch, so it is not derived from the context of an event. It
+// carries the subject when all of the events share it.
+func (b *Batcher) batchContext(items []*batchItem) context.Context {
+	ctx := context.Background()
+	logger := b.logger.With(zap.Int("batch-size", len(items)))
+
+	subj := GetSubjectFromContext(items[0].ctx)
+	for _, item := range items[1:] {
+		if GetSubjectFromContext(item.ctx) != subj {
+			subj = ""
+			break
+		}
+	}
+
+	if subj != "" {
+		ctx = SaveSubjectToContext(ctx, subj)
+		logger = logger.With(zap.String("subject", subj))
+	}
+
+	return SaveLoggerToContext(ctx, logger)
+}
+
+// callHandler calls the batch handler and converts a panic into a *PanicError
+// for every event in the batch
+func (b *Batcher) callHandler(ctx context.Context, events []*govevents.Event) (errs []error) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+
+		perr := &PanicError{Value: p, Stack: debug.Stack()}
+
+		errs = make([]error, len(events))
+		for i := range errs {
+			errs[i] = perr
+		}
+	}()
+
+	return b.handler(ctx, events)
+}
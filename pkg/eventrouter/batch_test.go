@@ -0,0 +1,197 @@
+package eventrouter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errBatch = errors.New("batch event failed")
+
+// handleAll calls Handle concurrently for the events and returns their errors
+func handleAll(ctx context.Context, b *Batcher, events []*govevents.Event) []error {
+	errs := make([]error, len(events))
+
+	var wg sync.WaitGroup
+
+	for i, e := range events {
+		wg.Go(func() {
+			errs[i] = b.Handle(ctx, e)
+		})
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+func TestBatcherResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []BatcherOpt
+		results func(events []*govevents.Event) []error
+		want    []error
+	}{
+		{
+			name: "full batch",
+			opts: []BatcherOpt{BatcherWithMaxSize(3), BatcherWithMaxWait(time.Hour)},
+			results: func(events []*govevents.Event) []error {
+				errs := make([]error, len(events))
+				for i, e := range events {
+					if e.Version == "2" {
+						errs[i] = errBatch
+					}
+				}
+
+				return errs
+			},
+			want: []error{nil, errBatch, nil},
+		},
+		{
+			name:    "max wait",
+			opts:    []BatcherOpt{BatcherWithMaxSize(10), BatcherWithMaxWait(time.Millisecond)},
+			results: func(events []*govevents.Event) []error { return make([]error, len(events)) },
+			want:    []error{nil, nil, nil},
+		},
+		{
+			name:    "result mismatch",
+			opts:    []BatcherOpt{BatcherWithMaxSize(3)},
+			results: func([]*govevents.Event) []error { return nil },
+			want:    []error{ErrBatchResultMismatch, ErrBatchResultMismatch, ErrBatchResultMismatch},
+		},
+		{
+			name:    "panic",
+			opts:    []BatcherOpt{BatcherWithMaxSize(3)},
+			results: func([]*govevents.Event) []error { panic("batch panic") },
+			want:    []error{ErrHandlerPanic, ErrHandlerPanic, ErrHandlerPanic},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBatcher(func(_ context.Context, events []*govevents.Event) []error {
+				return tt.results(events)
+			}, tt.opts...)
+
+			events := []*govevents.Event{{Version: "1"}, {Version: "2"}, {Version: "3"}}
+			errs := handleAll(context.Background(), b, events)
+
+			for i, want := range tt.want {
+				if want == nil {
+					assert.NoError(t, errs[i])
+					continue
+				}
+
+				assert.ErrorIs(t, errs[i], want)
+			}
+		})
+	}
+}
+
+func TestBatcherContext(t *testing.T) {
+	var (
+		subj string
+		err  error
+	)
+
+	b := NewBatcher(func(ctx context.Context, events []*govevents.Event) []error {
+		subj, err = GetSubjectFromContext(ctx), ctx.Err()
+		return make([]error, len(events))
+	}, BatcherWithMaxSize(2))
+
+	ctx, cancel := context.WithCancel(SaveSubjectToContext(context.Background(), "groups"))
+
+	go func() {
+		_ = b.Handle(ctx, &govevents.Event{})
+	}()
+
+	// the batch is not cancelled with the event that did not fill it
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		return b.current != nil
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, b.Handle(SaveSubjectToContext(context.Background(), "groups"), &govevents.Event{}))
+	cancel()
+
+	assert.Equal(t, "groups", subj)
+	assert.NoError(t, err)
+}
+
+func TestBatcherRemovesCancelledEvents(t *testing.T) {
+	var got []string
+
+	b := NewBatcher(func(_ context.Context, events []*govevents.Event) []error {
+		for _, e := range events {
+			got = append(got, e.Version)
+		}
+
+		return make([]error, len(events))
+	}, BatcherWithMaxSize(2), BatcherWithMaxWait(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.ErrorIs(t, b.Handle(ctx, &govevents.Event{Version: "cancelled"}), context.Canceled)
+
+	handleAll(context.Background(), b, []*govevents.Event{{Version: "1"}, {Version: "2"}})
+
+	assert.ElementsMatch(t, []string{"1", "2"}, got)
+}
+
+// workerDispatcher lends workers from a pool
+type workerDispatcher struct {
+	testDispatcher
+
+	workers chan struct{}
+}
+
+func (d *workerDispatcher) ReleaseWorker() func() {
+	<-d.workers
+	return func() { d.workers <- struct{}{} }
+}
+
+func TestBatcherReleasesWorkers(t *testing.T) {
+	const size = 5
+
+	d := &workerDispatcher{workers: make(chan struct{}, 1)}
+	ctx := SaveDispatcherToContext(context.Background(), d)
+
+	b := NewBatcher(func(_ context.Context, events []*govevents.Event) []error {
+		return make([]error, len(events))
+	}, BatcherWithMaxSize(size), BatcherWithMaxWait(time.Hour))
+
+	var wg sync.WaitGroup
+
+	// every event takes the only worker before it is handled, the batch only
+	// fills if the waiting events give it back
+	for range size {
+		wg.Go(func() {
+			d.workers <- struct{}{}
+			defer func() { <-d.workers }()
+
+			assert.NoError(t, b.Handle(ctx, &govevents.Event{}))
+		})
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("batch did not fill")
+	}
+}
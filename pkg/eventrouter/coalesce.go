@@ -0,0 +1,309 @@
+package eventrouter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultCoalesceWindow is the default duration the Coalescer waits for
+	// more events for the same key before dispatching
+	DefaultCoalesceWindow = 1 * time.Second
+	// DefaultCoalesceMaxWait is the default maximum duration the Coalescer
+	// holds an event before dispatching, regardless of new events
+	DefaultCoalesceMaxWait = 5 * time.Second
+	// DefaultCoalesceMaxAttempts is the default maximum number of times the
+	// Coalescer dispatches an event whose handler fails
+	DefaultCoalesceMaxAttempts = 3
+)
+
+// CoalesceKeyFunc derives the key that events are coalesced by, events with
+// an empty key are not coalesced
+type CoalesceKeyFunc func(subj string, event *govevents.Event) string
+
+// Coalescer is responsible for collapsing bursts of events for the same
+// resource into a single dispatch.
+type Coalescer struct {
+	logger *zap.Logger
+
+	window            time.Duration
+	maxWait           time.Duration
+	keyFunc           CoalesceKeyFunc
+	maxAttempts       int
+	mergeCreateUpdate bool
+	dropCreateDelete  bool
+
+	mu      sync.Mutex
+	pending map[string]*pendingEvent
+	// dispatchers are the dispatchers the Coalescer registered its Flush with
+	dispatchers map[Dispatcher]struct{}
+}
+
+type pendingEvent struct {
+	ctx      context.Context
+	event    *govevents.Event
+	next     Handler
+	first    time.Time
+	timer    *time.Timer
+	attempts int
+}
+
+// CoalescerOpt is a function type for configuring Coalescer.
+type CoalescerOpt func(*Coalescer)
+
+// NewCoalescer creates a new instance of Coalescer with the provided options.
+func NewCoalescer(opts ...CoalescerOpt) *Coalescer {
+	c := &Coalescer{
+		logger:      zap.NewNop(),
+		window:      DefaultCoalesceWindow,
+		maxWait:     DefaultCoalesceMaxWait,
+		keyFunc:     DefaultCoalesceKey,
+		maxAttempts: DefaultCoalesceMaxAttempts,
+		pending:     make(map[string]*pendingEvent),
+		dispatchers: make(map[Dispatcher]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// CoalescerWithWindow sets how long the Coalescer waits for more events for
+// the same key, the window restarts with every new event.
+func CoalescerWithWindow(d time.Duration) CoalescerOpt {
+	return func(c *Coalescer) {
+		c.window = d
+	}
+}
+
+// CoalescerWithMaxWait sets the maximum duration an event is held, measured
+// from the first event for the key, so latency stays bounded during a
+// continuous stream of events.
+func CoalescerWithMaxWait(d time.Duration) CoalescerOpt {
+	return func(c *Coalescer) {
+		c.maxWait = d
+	}
+}
+
+// CoalescerWithKeyFunc sets the function deriving the key events are
+// coalesced by.
+func CoalescerWithKeyFunc(fn CoalesceKeyFunc) CoalescerOpt {
+	return func(c *Coalescer) {
+		c.keyFunc = fn
+	}
+}
+
+// CoalescerWithMaxAttempts sets the maximum number of times an event is
+// dispatched when its handler fails, a failed event is held again for the
+// window unless a newer event for the same key arrived in the meantime.
+func CoalescerWithMaxAttempts(n int) CoalescerOpt {
+	return func(c *Coalescer) {
+		c.maxAttempts = n
+	}
+}
+
+// CoalescerWithMergeCreateUpdate merges an update into a pending create, the
+// latest event is dispatched as a create instead of replacing the create.
+func CoalescerWithMergeCreateUpdate() CoalescerOpt {
+	return func(c *Coalescer) {
+		c.mergeCreateUpdate = true
+	}
+}
+
+// CoalescerWithDropCreateDelete drops a pending create when a delete for the
+// same key arrives, neither event is dispatched.
+func CoalescerWithDropCreateDelete() CoalescerOpt {
+	return func(c *Coalescer) {
+		c.dropCreateDelete = true
+	}
+}
+
+// CoalescerWithLogger sets the logger for Coalescer.
+func CoalescerWithLogger(logger *zap.Logger) CoalescerOpt {
+	return func(c *Coalescer) {
+		c.logger = logger
+	}
+}
+
+// DefaultCoalesceKey coalesces events by subject and extension resource ID
+func DefaultCoalesceKey(subj string, event *govevents.Event) string {
+	if event.ExtensionResourceID == "" {
+		return ""
+	}
+
+	return subj + "/" + event.ExtensionResourceID
+}
+
+// MWCoalesce returns a middleware that holds events per key and dispatches
+// only the latest one, once no new event arrived for the window or the max
+// wait is reached.
+//
+// Held events are accepted immediately, the middleware returns nil and the
+// event is dispatched in the background with a context that is detached from
+// the caller's cancellation. When the context carries a Dispatcher, e.g. when
+// the router is run by the server, the event is dispatched with it and held
+// events are flushed when it shuts down. Failed dispatches are logged and
+// retried, see CoalescerWithMaxAttempts. The middleware can be shared by the
+// routes of different actions, the handler of the latest event is used for
+// the dispatch.
+func (c *Coalescer) MWCoalesce(next Handler) Handler {
+	return func(ctx context.Context, event *govevents.Event) error {
+		subj := GetSubjectFromContext(ctx)
+
+		key := c.keyFunc(subj, event)
+		if key == "" {
+			return next(ctx, event)
+		}
+
+		markDeferred(ctx)
+
+		ctx = context.WithoutCancel(ctx)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if d := GetDispatcherFromContext(ctx); d != nil {
+			if _, ok := c.dispatchers[d]; !ok {
+				c.dispatchers[d] = struct{}{}
+				d.OnShutdown(c.Flush)
+			}
+		}
+
+		pe, ok := c.pending[key]
+		if !ok {
+			pe = &pendingEvent{ctx: ctx, event: event, next: next, first: time.Now()}
+			pe.timer = time.AfterFunc(c.window, func() { c.flush(key, pe) })
+			c.pending[key] = pe
+
+			return nil
+		}
+
+		eventsCoalescedTotal.WithLabelValues(subj, pe.event.Action).Inc()
+
+		switch {
+		case c.dropCreateDelete &&
+			pe.event.Action == govevents.GovernorEventCreate &&
+			event.Action == govevents.GovernorEventDelete:
+			c.logger.Debug(
+				"dropping create and delete events",
+				zap.String("key", key),
+				zap.String("subject", subj),
+				zap.String("component", "coalesce-middleware"),
+			)
+
+			pe.timer.Stop()
+			delete(c.pending, key)
+
+			eventsCoalescedTotal.WithLabelValues(subj, event.Action).Inc()
+
+			return nil
+		case c.mergeCreateUpdate &&
+			pe.event.Action == govevents.GovernorEventCreate &&
+			event.Action == govevents.GovernorEventUpdate:
+			// keep the create handler, with the latest state of the resource
+			merged := *event
+			merged.Action = govevents.GovernorEventCreate
+			pe.event = &merged
+			pe.ctx = ctx
+			pe.attempts = 0
+		default:
+			pe.event = event
+			pe.ctx = ctx
+			pe.next = next
+			pe.attempts = 0
+		}
+
+		wait := min(c.window, c.maxWait-time.Since(pe.first))
+		pe.timer.Reset(max(wait, 0))
+
+		return nil
+	}
+}
+
+// Flush dispatches all pending events immediately and waits for the handlers
+// to return, e.g. before shutting down. Failed events are not retried.
+func (c *Coalescer) Flush() {
+	c.mu.Lock()
+
+	pending := c.pending
+	c.pending = make(map[string]*pendingEvent)
+
+	c.mu.Unlock()
+
+	for key, pe := range pending {
+		pe.timer.Stop()
+
+		if err := c.dispatch(pe); err != nil {
+			c.logError(key, pe, "giving up on coalesced event", err)
+		}
+	}
+}
+
+func (c *Coalescer) flush(key string, pe *pendingEvent) {
+	c.mu.Lock()
+
+	// the event may have been flushed or dropped already
+	if c.pending[key] != pe {
+		c.mu.Unlock()
+		return
+	}
+
+	delete(c.pending, key)
+
+	c.mu.Unlock()
+
+	err := c.dispatch(pe)
+	if err == nil {
+		return
+	}
+
+	pe.attempts++
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// a newer event for the key supersedes the failed one
+	if _, ok := c.pending[key]; ok || pe.attempts >= c.maxAttempts {
+		c.logError(key, pe, "giving up on coalesced event", err)
+		return
+	}
+
+	c.logError(key, pe, "error processing coalesced event, retrying", err)
+
+	pe.first = time.Now()
+	pe.timer = time.AfterFunc(c.window, func() { c.flush(key, pe) })
+	c.pending[key] = pe
+}
+
+// dispatch runs the handler of the event, with the dispatcher of its context
+// if any
+func (c *Coalescer) dispatch(pe *pendingEvent) error {
+	handler := func(ctx context.Context, event *govevents.Event) error {
+		return callHandler(ctx, pe.next, event)
+	}
+
+	if d := GetDispatcherFromContext(pe.ctx); d != nil {
+		return d.Dispatch(pe.ctx, pe.event, handler)
+	}
+
+	return handler(pe.ctx, pe.event)
+}
+
+func (c *Coalescer) logError(key string, pe *pendingEvent, msg string, err error) {
+	c.logger.Error(
+		msg,
+		zap.Error(err),
+		zap.String("key", key),
+		zap.Int("attempt", pe.attempts),
+		zap.String("action", pe.event.Action),
+		zap.String("subject", GetSubjectFromContext(pe.ctx)),
+		zap.String("resource-id", pe.event.ExtensionResourceID),
+		zap.String("component", "coalesce-middleware"),
+	)
+}
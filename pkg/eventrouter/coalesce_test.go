@@ -0,0 +1,156 @@
+package eventrouter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errCoalesce = errors.New("coalesced handler failed")
+
+func coalesceEvent(action, version string) *govevents.Event {
+	return &govevents.Event{Action: action, ExtensionResourceID: "resource-id", Version: version}
+}
+
+// testDispatcher records the dispatched events and the shutdown functions
+type testDispatcher struct {
+	mu         sync.Mutex
+	dispatched []*govevents.Event
+	shutdown   []func()
+}
+
+func (d *testDispatcher) Dispatch(ctx context.Context, event *govevents.Event, handler Handler) error {
+	d.mu.Lock()
+	d.dispatched = append(d.dispatched, event)
+	d.mu.Unlock()
+
+	return handler(ctx, event)
+}
+
+func (d *testDispatcher) ReleaseWorker() func() { return func() {} }
+
+func (d *testDispatcher) OnShutdown(fn func()) {
+	d.shutdown = append(d.shutdown, fn)
+}
+
+func TestCoalescerLatestEvent(t *testing.T) {
+	var got []string
+
+	c := NewCoalescer(CoalescerWithWindow(time.Hour))
+
+	r := NewRouter()
+	r.Update("coalesce", func(_ context.Context, e *govevents.Event) error {
+		got = append(got, e.Version)
+		return nil
+	}, c.MWCoalesce)
+
+	for _, v := range []string{"1", "2", "3"} {
+		require.NoError(t, r.Process(context.Background(), "coalesce", coalesceEvent(govevents.GovernorEventUpdate, v)))
+	}
+
+	assert.Empty(t, got)
+
+	c.Flush()
+	assert.Equal(t, []string{"3"}, got)
+}
+
+func TestCoalescerDispatcher(t *testing.T) {
+	var calls atomic.Int32
+
+	d := &testDispatcher{}
+	c := NewCoalescer(CoalescerWithWindow(time.Hour))
+
+	r := NewRouter()
+	r.Update("coalesce", func(context.Context, *govevents.Event) error {
+		calls.Add(1)
+		return nil
+	}, c.MWCoalesce)
+
+	ctx := SaveDispatcherToContext(context.Background(), d)
+
+	require.NoError(t, r.Process(ctx, "coalesce", coalesceEvent(govevents.GovernorEventUpdate, "1")))
+	require.NoError(t, r.Process(ctx, "coalesce", coalesceEvent(govevents.GovernorEventUpdate, "2")))
+
+	// the coalescer registers its flush once with the dispatcher
+	require.Len(t, d.shutdown, 1)
+
+	d.shutdown[0]()
+
+	require.Len(t, d.dispatched, 1)
+	assert.Equal(t, "2", d.dispatched[0].Version)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestCoalescerRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		calls    int32
+	}{
+		{"succeeds on retry", 1, 2},
+		{"gives up after max attempts", 10, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			done := make(chan struct{})
+			c := NewCoalescer(CoalescerWithWindow(time.Millisecond), CoalescerWithMaxAttempts(3))
+
+			r := NewRouter()
+			r.Update("coalesce", func(context.Context, *govevents.Event) error {
+				n := calls.Add(1)
+				if n == tt.calls {
+					defer close(done)
+				}
+
+				if n <= tt.failures {
+					return errCoalesce
+				}
+
+				return nil
+			}, c.MWCoalesce)
+
+			require.NoError(t, r.Process(context.Background(), "coalesce", coalesceEvent(govevents.GovernorEventUpdate, "1")))
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("event was not dispatched")
+			}
+
+			// no more attempts are made
+			time.Sleep(10 * time.Millisecond) //nolint: mnd
+			assert.Equal(t, tt.calls, calls.Load())
+		})
+	}
+}
+
+func TestCoalescerDropCreateDelete(t *testing.T) {
+	var calls atomic.Int32
+
+	c := NewCoalescer(CoalescerWithWindow(time.Hour), CoalescerWithDropCreateDelete())
+
+	r := NewRouter()
+	handler := func(context.Context, *govevents.Event) error {
+		calls.Add(1)
+		return nil
+	}
+
+	r.Create("coalesce", handler, c.MWCoalesce)
+	r.Delete("coalesce", handler, c.MWCoalesce)
+
+	require.NoError(t, r.Process(context.Background(), "coalesce", coalesceEvent(govevents.GovernorEventCreate, "1")))
+	require.NoError(t, r.Process(context.Background(), "coalesce", coalesceEvent(govevents.GovernorEventDelete, "2")))
+
+	c.Flush()
+	assert.Zero(t, calls.Load())
+}
@@ -1,10 +1,101 @@
 package eventrouter
 
-import "context"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 
-type contextKey struct{}
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"go.uber.org/zap"
+)
 
-var subjectCtxKey = contextKey{}
+type contextKey int
+
+const (
+	subjectCtxKey contextKey = iota
+	timeoutCtxKey
+	loggerCtxKey
+	actionCtxKey
+	receivedAtCtxKey
+	deliveryAttemptCtxKey
+	abandonedCtxKey
+	deferredCtxKey
+	dispatcherCtxKey
+)
+
+// eventContext carries the metadata of the event being processed. The router
+// saves all of the metadata with a single allocation instead of one context
+// per value, and the request scoped logger is only built when it is used.
+// Values saved with the Save functions on top of an eventContext take
+// precedence as usual.
+type eventContext struct {
+	context.Context
+
+	subject    string
+	event      *govevents.Event
+	receivedAt time.Time
+	attempt    int
+	// abandoned limits the handlers abandoned by the timeout middlewares
+	abandoned chan struct{}
+	// deferred is set when a middleware defers the processing of the event
+	deferred atomic.Bool
+
+	baseLogger *zap.Logger
+	loggerOnce sync.Once
+	logger     *zap.Logger
+}
+
+// newEventContext returns a context carrying the metadata of the event, the
+// received time and delivery attempt are taken from the parent when set
+func newEventContext(parent context.Context, subj string, event *govevents.Event, logger *zap.Logger) *eventContext {
+	ec := &eventContext{
+		Context:    parent,
+		subject:    subj,
+		event:      event,
+		receivedAt: GetReceivedAtFromContext(parent),
+		attempt:    GetDeliveryAttemptFromContext(parent),
+		baseLogger: logger,
+	}
+
+	if ec.receivedAt.IsZero() {
+		ec.receivedAt = time.Now()
+	}
+
+	if ec.attempt == 0 {
+		ec.attempt = 1
+	}
+
+	return ec
+}
+
+// Value returns the eventContext itself for the metadata keys, so the getters
+// can read the fields without boxing them
+func (ec *eventContext) Value(key any) any {
+	switch key {
+	case subjectCtxKey, actionCtxKey, receivedAtCtxKey, deliveryAttemptCtxKey, loggerCtxKey, deferredCtxKey:
+		return ec
+	case abandonedCtxKey:
+		return ec.abandoned
+	}
+
+	return ec.Context.Value(key)
+}
+
+// requestLogger returns the request scoped logger, building it on first use
+func (ec *eventContext) requestLogger() *zap.Logger {
+	ec.loggerOnce.Do(func() {
+		ec.logger = ec.baseLogger.With(
+			zap.String("subject", ec.subject),
+			zap.String("action", ec.event.Action),
+			zap.String("resource-id", ec.event.ExtensionResourceID),
+			zap.String("correlation-id", eventCorrelationID(ec.event)),
+			zap.Int("attempt", ec.attempt),
+		)
+	})
+
+	return ec.logger
+}
 
 // SaveSubjectToContext saves the subject to the context
 func SaveSubjectToContext(ctx context.Context, subject string) context.Context {
@@ -13,10 +104,113 @@ func SaveSubjectToContext(ctx context.Context, subject string) context.Context {
 
 // GetSubjectFromContext gets the subject from the context
 func GetSubjectFromContext(ctx context.Context) string {
-	subject, ok := ctx.Value(subjectCtxKey).(string)
+	switch v := ctx.Value(subjectCtxKey).(type) {
+	case string:
+		return v
+	case *eventContext:
+		return v.subject
+	}
+
+	return ""
+}
+
+// GetTimeoutFromContext gets the handler timeout applied by a timeout
+// middleware from the context, it returns 0 if no timeout was applied
+func GetTimeoutFromContext(ctx context.Context) time.Duration {
+	d, ok := ctx.Value(timeoutCtxKey).(time.Duration)
 	if !ok {
-		return ""
+		return 0
+	}
+
+	return d
+}
+
+// SaveLoggerToContext saves the request scoped logger to the context
+func SaveLoggerToContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey, logger)
+}
+
+// GetLoggerFromContext gets the request scoped logger from the context. The
+// router populates the logger with the subject, action, resource ID,
+// correlation ID, trace ID and delivery attempt of the event being processed.
+// It returns a no-op logger if the context has no logger.
+func GetLoggerFromContext(ctx context.Context) *zap.Logger {
+	switch v := ctx.Value(loggerCtxKey).(type) {
+	case *zap.Logger:
+		return v
+	case *eventContext:
+		return v.requestLogger()
+	}
+
+	return zap.NewNop()
+}
+
+// SaveActionToContext saves the event action to the context
+func SaveActionToContext(ctx context.Context, action string) context.Context {
+	return context.WithValue(ctx, actionCtxKey, action)
+}
+
+// GetActionFromContext gets the event action from the context
+func GetActionFromContext(ctx context.Context) string {
+	switch v := ctx.Value(actionCtxKey).(type) {
+	case string:
+		return v
+	case *eventContext:
+		return v.event.Action
 	}
 
-	return subject
+	return ""
+}
+
+// SaveReceivedAtToContext saves the time the event was received to the context
+func SaveReceivedAtToContext(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, receivedAtCtxKey, t)
+}
+
+// GetReceivedAtFromContext gets the time the event was received from the
+// context, it returns the zero time if it is not set
+func GetReceivedAtFromContext(ctx context.Context) time.Time {
+	switch v := ctx.Value(receivedAtCtxKey).(type) {
+	case time.Time:
+		return v
+	case *eventContext:
+		return v.receivedAt
+	}
+
+	return time.Time{}
+}
+
+// SaveDeliveryAttemptToContext saves the delivery attempt of the event to the
+// context, the first delivery is attempt 1
+func SaveDeliveryAttemptToContext(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, deliveryAttemptCtxKey, attempt)
+}
+
+// GetDeliveryAttemptFromContext gets the delivery attempt of the event from
+// the context, it returns 0 if it is not set
+func GetDeliveryAttemptFromContext(ctx context.Context) int {
+	switch v := ctx.Value(deliveryAttemptCtxKey).(type) {
+	case int:
+		return v
+	case *eventContext:
+		return v.attempt
+	}
+
+	return 0
+}
+
+// markDeferred records that a middleware deferred the processing of the event,
+// e.g. the Coalescer holding it, so a nil error from the handler chain does
+// not mean that the event was processed
+func markDeferred(ctx context.Context) {
+	if ec, ok := ctx.Value(deferredCtxKey).(*eventContext); ok {
+		ec.deferred.Store(true)
+	}
+}
+
+// isDeferred reports whether a middleware deferred the processing of the event
+func isDeferred(ctx context.Context) bool {
+	ec, ok := ctx.Value(deferredCtxKey).(*eventContext)
+
+	return ok && ec.deferred.Load()
 }
@@ -0,0 +1,145 @@
+package eventrouter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestEventContext(t *testing.T) {
+	receivedAt := time.Now().Add(-time.Minute)
+	event := &govevents.Event{
+		Action:              govevents.GovernorEventUpdate,
+		ExtensionResourceID: "resource-id",
+		Headers: map[string][]string{
+			govevents.GovernorEventCorrelationIDHeader: {"cid"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		parent     context.Context
+		receivedAt func(time.Time) bool
+		attempt    int
+	}{
+		{
+			name:       "defaults",
+			parent:     context.Background(),
+			receivedAt: func(t time.Time) bool { return time.Since(t) < time.Second },
+			attempt:    1,
+		},
+		{
+			name: "from parent",
+			parent: SaveDeliveryAttemptToContext(
+				SaveReceivedAtToContext(context.Background(), receivedAt), 3,
+			),
+			receivedAt: receivedAt.Equal,
+			attempt:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newEventContext(tt.parent, "groups", event, zap.NewNop())
+
+			assert.Equal(t, "groups", GetSubjectFromContext(ctx))
+			assert.Equal(t, govevents.GovernorEventUpdate, GetActionFromContext(ctx))
+			assert.True(t, tt.receivedAt(GetReceivedAtFromContext(ctx)))
+			assert.Equal(t, tt.attempt, GetDeliveryAttemptFromContext(ctx))
+			assert.Zero(t, GetTimeoutFromContext(ctx))
+		})
+	}
+}
+
+func TestEventContextOverrides(t *testing.T) {
+	ctx := context.Context(newEventContext(context.Background(), "groups", &govevents.Event{Action: "CREATE"}, zap.NewNop()))
+
+	logger := zap.NewExample()
+
+	// values saved on top of the event context take precedence
+	ctx = SaveSubjectToContext(ctx, "users")
+	ctx = SaveActionToContext(ctx, "DELETE")
+	ctx = SaveDeliveryAttemptToContext(ctx, 5)
+	ctx = SaveLoggerToContext(ctx, logger)
+
+	assert.Equal(t, "users", GetSubjectFromContext(ctx))
+	assert.Equal(t, "DELETE", GetActionFromContext(ctx))
+	assert.Equal(t, 5, GetDeliveryAttemptFromContext(ctx))
+	assert.Same(t, logger, GetLoggerFromContext(ctx))
+}
+
+func TestEventContextEmpty(t *testing.T) {
+	ctx := context.Background()
+
+	assert.Empty(t, GetSubjectFromContext(ctx))
+	assert.Empty(t, GetActionFromContext(ctx))
+	assert.True(t, GetReceivedAtFromContext(ctx).IsZero())
+	assert.Zero(t, GetDeliveryAttemptFromContext(ctx))
+	assert.NotNil(t, GetLoggerFromContext(ctx))
+}
+
+func TestEventContextLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+
+	r := NewRouter(WithLogger(zap.New(core)))
+	r.Update("groups", func(ctx context.Context, _ *govevents.Event) error {
+		GetLoggerFromContext(ctx).Info("handling event")
+		return nil
+	})
+
+	ctx := SaveDeliveryAttemptToContext(context.Background(), 2) //nolint: mnd
+
+	require.NoError(t, r.Process(ctx, "groups", &govevents.Event{
+		Action:              govevents.GovernorEventUpdate,
+		ExtensionResourceID: "resource-id",
+		Headers: map[string][]string{
+			govevents.GovernorEventCorrelationIDHeader: {"cid"},
+		},
+	}))
+
+	entries := logs.FilterMessage("handling event").All()
+	require.Len(t, entries, 1)
+
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "eventrouter", fields["component"])
+	assert.Equal(t, "groups", fields["subject"])
+	assert.Equal(t, govevents.GovernorEventUpdate, fields["action"])
+	assert.Equal(t, "resource-id", fields["resource-id"])
+	assert.Equal(t, "cid", fields["correlation-id"])
+	assert.Equal(t, int64(2), fields["attempt"])
+}
+
+func TestCorrelationIDSkipLogsWithEventLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+
+	r := NewRouter(
+		WithLogger(zap.New(core)),
+		WithCorrelationIDProcessor(NewCorrelationIDProcessor(CorrelationIDProcessorWithSkipStrategySkipAll())),
+	)
+	r.Update("groups", noopHandler)
+
+	event := &govevents.Event{
+		Action:              govevents.GovernorEventUpdate,
+		ExtensionResourceID: "resource-id",
+		Headers: map[string][]string{
+			govevents.GovernorEventCorrelationIDHeader: {"cid"},
+		},
+	}
+
+	require.NoError(t, r.Process(context.Background(), "groups", event))
+	require.NoError(t, r.Process(context.Background(), "groups", event))
+
+	entries := logs.FilterMessage("skipping event").All()
+	require.Len(t, entries, 1)
+
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "resource-id", fields["resource-id"])
+	assert.Equal(t, "cid", fields["correlation-id"])
+	assert.Equal(t, "skip-all", fields["skip-rule"])
+}
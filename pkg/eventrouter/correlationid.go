@@ -2,10 +2,14 @@ package eventrouter
 
 import (
 	"context"
+	"slices"
 
+	"github.com/hashicorp/golang-lru/v2/expirable"
 	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
 	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -17,9 +21,24 @@ type CorrelationIDProcessor struct {
 	// correlation IDs. This is used to prevent the extension from reacting to its own
 	// updates.
 	histcache historycache.HistoryCache
-	// skippableRoutes is a map of routes that can be skipped based on the
-	// correlation ID and skip strategy
-	skippableRoutes map[string]map[string]struct{}
+	// skipRules are the rules of the skip strategy, deciding which events
+	// recognized by their correlation ID can be skipped
+	skipRules []SkipRule
+	// selfOriginated enables skipping only the events caused by the writes of
+	// the extension recorded with RecordWrite
+	selfOriginated bool
+	// generator generates the correlation IDs of EnsureCorrelationID
+	generator CorrelationIDGenerator
+	// selfActors are the actor IDs of the extension's own identities
+	selfActors map[string]struct{}
+	// actorSkipRules are the rules deciding which events caused by one of
+	// selfActors can be skipped, nil means skipRules
+	actorSkipRules []SkipRule
+	// erdResolver resolves the ERD slugs of the skip rules
+	erdResolver ERDResolver
+	// writeSpans are the spans of the writes recorded with RecordWrite, keyed
+	// by correlation ID, nil disables the span links
+	writeSpans *expirable.LRU[string, trace.SpanContext]
 }
 
 // CorrelationIDProcessorOpt is a function type for configuring CorrelationIDProcessor.
@@ -28,11 +47,13 @@ type CorrelationIDProcessorOpt func(*CorrelationIDProcessor)
 // NewCorrelationIDProcessor creates a new instance of CorrelationIDProcessor with the provided options.
 func NewCorrelationIDProcessor(opts ...CorrelationIDProcessorOpt) *CorrelationIDProcessor {
 	p := &CorrelationIDProcessor{
-		logger:          zap.NewNop(),
-		histcache:       historycache.NewLocalCache(),
-		skippableRoutes: make(map[string]map[string]struct{}),
+		logger:    zap.NewNop(),
+		histcache: historycache.NewLocalCache(historycache.WithName("correlation-id")),
+		generator: NewCorrelationIDGenerator(""),
 	}
 
+	CorrelationIDProcessorWithWriteSpanCache(DefaultWriteSpanCacheSize, DefaultWriteSpanCacheTTL)(p)
+
 	// default skip strategy is to skip only update events
 	CorrelationIDProcessorWithSkipStrategyUpdateOnly()(p)
 
@@ -57,11 +78,71 @@ func CorrelationIDProcessorWithLogger(logger *zap.Logger) CorrelationIDProcessor
 	}
 }
 
+// CorrelationIDProcessorWithSelfOriginatedOnly makes the processor skip only
+// the events caused by the extension's own writes to the Governor API, as
+// recorded with RecordWrite, instead of any event whose correlation ID was
+// seen before. The history cache must implement historycache.KeyStore.
+func CorrelationIDProcessorWithSelfOriginatedOnly() CorrelationIDProcessorOpt {
+	return func(p *CorrelationIDProcessor) {
+		p.selfOriginated = true
+	}
+}
+
+// CorrelationIDProcessorWithSelfActors makes the processor skip the events
+// caused by the extension's own identities, i.e. events whose actor ID is one
+// of actorIDs, in addition to the events skipped based on their correlation
+// ID. The routes are skippable according to the skip strategy, unless they are
+// set with CorrelationIDProcessorWithActorSkipStrategy.
+func CorrelationIDProcessorWithSelfActors(actorIDs ...string) CorrelationIDProcessorOpt {
+	return func(p *CorrelationIDProcessor) {
+		p.selfActors = make(map[string]struct{}, len(actorIDs))
+
+		for _, id := range actorIDs {
+			if id != "" {
+				p.selfActors[id] = struct{}{}
+			}
+		}
+	}
+}
+
+// CorrelationIDProcessorWithActorSkipStrategy sets the routes that can be
+// skipped when the event was caused by one of the extension's own
+// identities, in the same format as CorrelationIDProcessorWithSkipStrategyCustom.
+func CorrelationIDProcessorWithActorSkipStrategy(sr map[string]map[string]struct{}) CorrelationIDProcessorOpt {
+	return func(p *CorrelationIDProcessor) {
+		p.actorSkipRules = skipRulesFromRoutes("actor-custom", sr)
+	}
+}
+
+// CorrelationIDProcessorWithActorSkipRules sets the rules deciding which
+// events caused by one of the extension's own identities can be skipped.
+func CorrelationIDProcessorWithActorSkipRules(rules ...SkipRule) CorrelationIDProcessorOpt {
+	return func(p *CorrelationIDProcessor) {
+		p.actorSkipRules = rules
+	}
+}
+
+// CorrelationIDProcessorWithSkipRules sets a rule-based skip strategy for
+// CorrelationIDProcessor, replacing any other skip strategy.
+func CorrelationIDProcessorWithSkipRules(rules ...SkipRule) CorrelationIDProcessorOpt {
+	return func(p *CorrelationIDProcessor) {
+		p.skipRules = rules
+	}
+}
+
+// CorrelationIDProcessorWithERDResolver sets the resolver of the ERD slugs
+// used in skip rules.
+func CorrelationIDProcessorWithERDResolver(r ERDResolver) CorrelationIDProcessorOpt {
+	return func(p *CorrelationIDProcessor) {
+		p.erdResolver = r
+	}
+}
+
 // CorrelationIDProcessorWithSkipStrategyUpdateOnly sets the skip strategy to skip only update events.
 func CorrelationIDProcessorWithSkipStrategyUpdateOnly() CorrelationIDProcessorOpt {
 	return func(p *CorrelationIDProcessor) {
-		p.skippableRoutes = map[string]map[string]struct{}{
-			govevents.GovernorEventUpdate: {"*": {}},
+		p.skipRules = []SkipRule{
+			{Name: "update-only", Actions: []string{govevents.GovernorEventUpdate}},
 		}
 	}
 }
@@ -69,13 +150,15 @@ func CorrelationIDProcessorWithSkipStrategyUpdateOnly() CorrelationIDProcessorOp
 // CorrelationIDProcessorWithSkipStrategySkipAll sets the skip strategy to skip all events.
 func CorrelationIDProcessorWithSkipStrategySkipAll() CorrelationIDProcessorOpt {
 	return func(p *CorrelationIDProcessor) {
-		p.skippableRoutes = map[string]map[string]struct{}{
-			govevents.GovernorEventUpdate:  {"*": {}},
-			govevents.GovernorEventCreate:  {"*": {}},
-			govevents.GovernorEventDelete:  {"*": {}},
-			govevents.GovernorEventApprove: {"*": {}},
-			govevents.GovernorEventDeny:    {"*": {}},
-			govevents.GovernorEventRevoke:  {"*": {}},
+		p.skipRules = []SkipRule{
+			{Name: "skip-all", Actions: []string{
+				govevents.GovernorEventUpdate,
+				govevents.GovernorEventCreate,
+				govevents.GovernorEventDelete,
+				govevents.GovernorEventApprove,
+				govevents.GovernorEventDeny,
+				govevents.GovernorEventRevoke,
+			}},
 		}
 	}
 }
@@ -83,84 +166,323 @@ func CorrelationIDProcessorWithSkipStrategySkipAll() CorrelationIDProcessorOpt {
 // CorrelationIDProcessorWithSkipStrategyCustom sets a custom skip strategy for CorrelationIDProcessor.
 func CorrelationIDProcessorWithSkipStrategyCustom(sr map[string]map[string]struct{}) CorrelationIDProcessorOpt {
 	return func(p *CorrelationIDProcessor) {
-		p.skippableRoutes = sr
+		p.skipRules = skipRulesFromRoutes("custom", sr)
 	}
 }
 
 // ShouldSkip returns true if the event should be skipped based on the
-// correlation ID and the skip strategy.
+// correlation ID and the skip strategy, along with the name of the skip rule
+// that decided it.
 //
 // A process is only skipped when the correlation ID is not empty and the
 // correlation ID is found in the history cache. The skip strategy is applied
-// to determine if the event should be skipped.
-func (p *CorrelationIDProcessor) ShouldSkip(ctx context.Context, cid, action, subj string) (bool, error) {
+// to determine if the event should be skipped. Only the action and subject are
+// known, so the skip rules on ERDs and predicates do not match, use
+// ShouldSkipEvent to evaluate them.
+func (p *CorrelationIDProcessor) ShouldSkip(ctx context.Context, cid, action, subj string) (bool, string, error) {
+	return p.shouldSkipSeen(ctx, cid, subj, &govevents.Event{Action: action}, false)
+}
+
+// ShouldSkipEvent returns true if the event on the subject should be skipped
+// based on its correlation ID, its actor and the skip strategy, along with the
+// name of the skip rule that decided it. When the processor only skips
+// self-originated events, the event is skipped if its correlation ID and
+// resource match a write recorded with RecordWrite, otherwise it is skipped if
+// its correlation ID was seen before.
+func (p *CorrelationIDProcessor) ShouldSkipEvent(ctx context.Context, subj string, event *govevents.Event) (bool, string, error) {
+	return p.skipEvent(ctx, subj, event, false)
+}
+
+// WouldSkipEvent is like ShouldSkipEvent, but it does not record the
+// correlation ID in the history cache. The history cache must implement
+// historycache.KeyStore.
+func (p *CorrelationIDProcessor) WouldSkipEvent(ctx context.Context, subj string, event *govevents.Event) (bool, string, error) {
+	return p.skipEvent(ctx, subj, event, true)
+}
+
+// WouldSkip is like ShouldSkip, but it does not record the correlation ID in
+// the history cache. The history cache must implement historycache.KeyStore.
+func (p *CorrelationIDProcessor) WouldSkip(ctx context.Context, cid, action, subj string) (bool, string, error) {
+	return p.shouldSkipSeen(ctx, cid, subj, &govevents.Event{Action: action}, true)
+}
+
+// skipEvent decides whether the event can be skipped, dryRun does not record
+// the correlation ID
+func (p *CorrelationIDProcessor) skipEvent(ctx context.Context, subj string, event *govevents.Event, dryRun bool) (bool, string, error) {
+	cid := eventCorrelationID(event)
+
+	var (
+		skip bool
+		rule string
+		err  error
+	)
+
+	if p.selfOriginated {
+		skip, rule, err = p.skipSelfOriginated(ctx, cid, subj, event)
+	} else {
+		skip, rule, err = p.shouldSkipSeen(ctx, cid, subj, event, dryRun)
+	}
+
+	// a matching Never rule is final, the actor skip rules cannot skip the
+	// event anymore
+	if err != nil || skip || rule != "" {
+		return skip, rule, err
+	}
+
+	actorSkip, actorRule, err := p.skipSelfActor(ctx, subj, event)
+	if err != nil {
+		return false, "", err
+	}
+
+	if actorRule == "" {
+		return false, rule, nil
+	}
+
+	return actorSkip, actorRule, nil
+}
+
+// shouldSkipSeen reports whether the correlation ID was seen before and the
+// skip rules allow skipping the event, dryRun does not record the ID
+func (p *CorrelationIDProcessor) shouldSkipSeen(ctx context.Context, cid, subj string, event *govevents.Event, dryRun bool) (bool, string, error) {
 	if cid == "" {
-		return false, nil
+		return false, "", nil
+	}
+
+	var (
+		exists bool
+		err    error
+	)
+
+	if dryRun {
+		ks, ok := p.histcache.(historycache.KeyStore)
+		if !ok {
+			return false, "", ErrHistoryCacheNotInspectable
+		}
+
+		exists, err = ks.Exists(ctx, cid)
+	} else {
+		exists, err = p.histcache.ExistsOrStore(ctx, cid)
 	}
 
-	exists, err := p.histcache.ExistsOrStore(ctx, cid)
 	if err != nil {
-		return false, err
+		return false, "", err
 	}
 
 	if !exists {
-		return false, nil
+		return false, "", nil
 	}
 
-	if _, ok := p.skippableRoutes[action]; ok {
-		if _, ok := p.skippableRoutes[action]["*"]; ok {
-			return true, nil
+	return p.matchSkipRules(ctx, p.skipRules, subj, event)
+}
+
+// skipSelfActor reports whether the event was caused by one of the
+// extension's own identities and the actor skip rules allow skipping it
+func (p *CorrelationIDProcessor) skipSelfActor(ctx context.Context, subj string, event *govevents.Event) (bool, string, error) {
+	if event.ActorID == "" {
+		return false, "", nil
+	}
+
+	if _, ok := p.selfActors[event.ActorID]; !ok {
+		return false, "", nil
+	}
+
+	rules := p.actorSkipRules
+	if rules == nil {
+		rules = p.skipRules
+	}
+
+	return p.matchSkipRules(ctx, rules, subj, event)
+}
+
+// matchSkipRules matches the event against the rules
+func (p *CorrelationIDProcessor) matchSkipRules(ctx context.Context, rules []SkipRule, subj string, event *govevents.Event) (bool, string, error) {
+	rule, skip, err := matchSkipRules(ctx, rules, p.erdResolver, subj, event)
+
+	return skip, rule, err
+}
+
+// RecordWrite records that the extension sent a write request with the
+// correlation ID to the Governor API for the resource, so the events it causes
+// can be recognized as self-originated. The resource ID matches the extension
+// resource, group, user or application ID of the events, an empty resource ID
+// matches any resource, e.g. when the ID of a created resource is not known
+// yet. The span of the context is kept to link the processing spans of the
+// events the write causes. The write is only recorded in the history cache
+// when the processor skips self-originated events only.
+func (p *CorrelationIDProcessor) RecordWrite(ctx context.Context, cid, resourceID string) error {
+	if cid == "" {
+		return nil
+	}
+
+	p.recordWriteSpan(ctx, cid)
+
+	if !p.selfOriginated {
+		return nil
+	}
+
+	ks, ok := p.histcache.(historycache.KeyStore)
+	if !ok {
+		return ErrHistoryCacheNotInspectable
+	}
+
+	if err := ks.Store(ctx, selfOriginatedKey(cid, resourceID), 0); err != nil {
+		return err
+	}
+
+	if ce := p.logger.Check(zap.DebugLevel, "recorded self-originated correlation ID"); ce != nil {
+		ce.Write(
+			zap.String("correlation-id", cid),
+			zap.String("resource-id", resourceID),
+			zap.String("component", "correlation-id-middleware"),
+		)
+	}
+
+	return nil
+}
+
+// RemoveWrite removes a write recorded with RecordWrite, e.g. when its request
+// failed and caused no event. Writes are recorded per correlation ID and
+// resource, so other recorded writes with the same correlation ID and resource
+// are removed too.
+func (p *CorrelationIDProcessor) RemoveWrite(ctx context.Context, cid, resourceID string) error {
+	if cid == "" || !p.selfOriginated {
+		return nil
+	}
+
+	if err := p.histcache.Remove(ctx, selfOriginatedKey(cid, resourceID)); err != nil {
+		return err
+	}
+
+	if ce := p.logger.Check(zap.DebugLevel, "removed self-originated correlation ID"); ce != nil {
+		ce.Write(
+			zap.String("correlation-id", cid),
+			zap.String("resource-id", resourceID),
+			zap.String("component", "correlation-id-middleware"),
+		)
+	}
+
+	return nil
+}
+
+// skipSelfOriginated reports whether the event was caused by a write recorded
+// with RecordWrite and the skip rules allow skipping it
+func (p *CorrelationIDProcessor) skipSelfOriginated(ctx context.Context, cid, subj string, event *govevents.Event) (bool, string, error) {
+	if cid == "" {
+		return false, "", nil
+	}
+
+	ks, ok := p.histcache.(historycache.KeyStore)
+	if !ok {
+		return false, "", ErrHistoryCacheNotInspectable
+	}
+
+	for _, key := range selfOriginatedKeys(cid, event) {
+		exists, err := ks.Exists(ctx, key)
+		if err != nil {
+			return false, "", err
+		}
+
+		if exists {
+			return p.matchSkipRules(ctx, p.skipRules, subj, event)
+		}
+	}
+
+	return false, "", nil
+}
+
+// selfOriginatedKey returns the history cache key of a write recorded with
+// RecordWrite
+func selfOriginatedKey(cid, resourceID string) string {
+	if resourceID == "" {
+		return "self." + cid
+	}
+
+	return "self." + cid + "." + resourceID
+}
+
+// selfOriginatedKeys returns the history cache keys of the writes that could
+// have caused the event, i.e. the writes without resource ID and the writes to
+// any of the resources of the event
+func selfOriginatedKeys(cid string, event *govevents.Event) []string {
+	keys := []string{selfOriginatedKey(cid, "")}
+
+	for _, id := range []string{event.ExtensionResourceID, event.GroupID, event.UserID, event.ApplicationID} {
+		if id == "" {
+			continue
 		}
 
-		if _, ok := p.skippableRoutes[action][subj]; ok {
-			return true, nil
+		if key := selfOriginatedKey(cid, id); !slices.Contains(keys, key) {
+			keys = append(keys, key)
 		}
 	}
 
-	return false, nil
+	return keys
+}
+
+// eventCorrelationID returns the correlation ID of the event
+func eventCorrelationID(event *govevents.Event) string {
+	if event.Headers == nil {
+		return ""
+	}
+
+	return nats.Header(event.Headers).Get(govevents.GovernorEventCorrelationIDHeader)
 }
 
 // MWInjectCorrelationID returns a middleware that injects the correlation ID into the context.
+// The correlation ID is also added to the baggage and the attributes of the
+// current span.
 func (p *CorrelationIDProcessor) MWInjectCorrelationID(next Handler) Handler {
 	return func(ctx context.Context, event *govevents.Event) error {
-		var (
-			headers nats.Header = event.Headers
-			cid     string
-		)
-
-		if headers != nil {
-			cid = headers.Get(govevents.GovernorEventCorrelationIDHeader)
+		cid := eventCorrelationID(event)
+		logger := p.eventLogger(ctx)
 
-			p.logger.Debug(
-				"extracted correlation ID from event",
-				zap.String("correlation-id", cid),
-				zap.String("component", "correlation-id-middleware"),
-			)
+		if cid != "" {
+			if ce := logger.Check(zap.DebugLevel, "extracted correlation ID from event"); ce != nil {
+				ce.Write(zap.String("component", "correlation-id-middleware"))
+			}
 		}
 
 		subj := GetSubjectFromContext(ctx)
 
-		skip, err := p.ShouldSkip(ctx, cid, event.Action, subj)
+		skip, rule, err := p.ShouldSkipEvent(ctx, subj, event)
 		if err != nil {
 			return err
 		}
 
-		if subj != "" && cid != "" && skip {
-			p.logger.Info(
+		if subj != "" && skip {
+			logger.Info(
 				"skipping event",
-				zap.String("action", event.Action),
-				zap.String("subject", subj),
-				zap.String("resource-id", event.ExtensionResourceID),
-				zap.String("correlation-id", cid),
+				zap.String("actor-id", event.ActorID),
+				zap.String("skip-rule", rule),
 				zap.String("component", "correlation-id-middleware"),
 			)
 
+			eventsSkippedTotal.WithLabelValues(subj, event.Action, rule).Inc()
+
+			trace.SpanFromContext(ctx).AddEvent("event skipped", trace.WithAttributes(
+				attribute.String(CorrelationIDKey, cid),
+				attribute.String("skip-rule", rule),
+			))
+
 			return nil
 		}
 
+		ctx = ContextWithCorrelationID(ctx, cid)
+
 		nextctx := govevents.InjectCorrelationID(ctx, cid)
 		err = next(nextctx, event)
 
 		return err
 	}
 }
+
+// eventLogger returns the logger of the event, which carries its subject,
+// action, resource ID and correlation ID, or the processor's logger when the
+// middleware is used outside of a router
+func (p *CorrelationIDProcessor) eventLogger(ctx context.Context) *zap.Logger {
+	if ctx.Value(loggerCtxKey) == nil {
+		return p.logger
+	}
+
+	return GetLoggerFromContext(ctx)
+}
@@ -0,0 +1,87 @@
+package eventrouter
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"go.uber.org/zap"
+)
+
+// CorrelationIDGenerator returns a new correlation ID. The IDs are used as
+// history cache keys, so they should only contain letters, digits and the
+// characters "-", "_", "=" and "/".
+type CorrelationIDGenerator func() string
+
+// NewCorrelationIDGenerator returns a generator of UUIDv7 correlation IDs with
+// the prefix, e.g. "my-extension-0190a3c4-1b2c-7d3e-8f40-123456789abc".
+// UUIDv7 are time ordered, so the IDs sort by creation time.
+func NewCorrelationIDGenerator(prefix string) CorrelationIDGenerator {
+	return func() string {
+		id, err := uuid.NewV7()
+		if err != nil {
+			// NewV7 only fails if the random source fails
+			id = uuid.New()
+		}
+
+		return prefix + id.String()
+	}
+}
+
+// CorrelationIDProcessorWithGenerator sets the generator used by
+// EnsureCorrelationID, the default generates UUIDv7 without prefix.
+func CorrelationIDProcessorWithGenerator(gen CorrelationIDGenerator) CorrelationIDProcessorOpt {
+	return func(p *CorrelationIDProcessor) {
+		p.generator = gen
+	}
+}
+
+// EnsureCorrelationID returns the context with a correlation ID. If the
+// context has none, a new ID is generated, added to the request scoped logger
+// and the current span, and registered in the history cache so the events
+// caused by requests made with it are recognized as self-originated. Use it
+// at the start of work that does not come from an event, e.g. reconciliation
+// loops or HTTP handlers, so all its requests share the same ID.
+//
+// The returned context carries the correlation ID even if it could not be
+// registered, in which case the error is returned as well.
+func (p *CorrelationIDProcessor) EnsureCorrelationID(ctx context.Context) (context.Context, string, error) {
+	if cid := govevents.ExtractCorrelationID(ctx); cid != "" {
+		return ctx, cid, nil
+	}
+
+	cid := p.generator()
+	ctx = govevents.InjectCorrelationID(ctx, cid)
+
+	logger := GetLoggerFromContext(ctx).With(zap.String("correlation-id", cid))
+	ctx = SaveLoggerToContext(ctx, logger)
+
+	ctx = ContextWithCorrelationID(ctx, cid)
+
+	if ce := p.logger.Check(zap.DebugLevel, "generated correlation ID"); ce != nil {
+		ce.Write(
+			zap.String("correlation-id", cid),
+			zap.String("component", "correlation-id-middleware"),
+		)
+	}
+
+	return ctx, cid, p.RegisterCorrelationID(ctx, cid)
+}
+
+// RegisterCorrelationID registers a correlation ID generated by the extension
+// in the history cache, so the events carrying it are skippable according to
+// the skip strategy. When the processor only skips self-originated events the
+// ID matches any resource, since the extension is the only source of the ID.
+func (p *CorrelationIDProcessor) RegisterCorrelationID(ctx context.Context, cid string) error {
+	if cid == "" {
+		return nil
+	}
+
+	if p.selfOriginated {
+		return p.RecordWrite(ctx, cid, "")
+	}
+
+	_, err := p.histcache.ExistsOrStore(ctx, cid)
+
+	return err
+}
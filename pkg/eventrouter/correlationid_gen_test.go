@@ -0,0 +1,110 @@
+package eventrouter
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// historyCacheOnly hides the KeyStore methods of a history cache
+type historyCacheOnly struct {
+	historycache.HistoryCache
+}
+
+func TestNewCorrelationIDGenerator(t *testing.T) {
+	gen := NewCorrelationIDGenerator("ext-")
+
+	first, second := gen(), gen()
+	require.NotEqual(t, first, second)
+
+	for _, cid := range []string{first, second} {
+		require.True(t, strings.HasPrefix(cid, "ext-"))
+
+		id, err := uuid.Parse(strings.TrimPrefix(cid, "ext-"))
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Version(7), id.Version())
+	}
+
+	// UUIDv7 are time ordered
+	assert.Less(t, first, second)
+}
+
+func TestEnsureCorrelationID(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []CorrelationIDProcessorOpt
+	}{
+		{"seen", nil},
+		{"self-originated", []CorrelationIDProcessorOpt{CorrelationIDProcessorWithSelfOriginatedOnly()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]CorrelationIDProcessorOpt{
+				CorrelationIDProcessorWithGenerator(func() string { return "generated" }),
+			}, tt.opts...)
+
+			p := NewCorrelationIDProcessor(opts...)
+
+			ctx, cid, err := p.EnsureCorrelationID(context.Background())
+			require.NoError(t, err)
+
+			assert.Equal(t, "generated", cid)
+			assert.Equal(t, "generated", govevents.ExtractCorrelationID(ctx))
+			assert.Equal(t, "generated", baggage.FromContext(ctx).Member(CorrelationIDKey).Value())
+
+			// the events caused by the requests made with the ID are skipped
+			skip, _, err := p.ShouldSkipEvent(context.Background(), "groups", &govevents.Event{
+				Action:              govevents.GovernorEventUpdate,
+				ExtensionResourceID: "resource-id",
+				Headers: map[string][]string{
+					govevents.GovernorEventCorrelationIDHeader: {cid},
+				},
+			})
+			require.NoError(t, err)
+			assert.True(t, skip)
+		})
+	}
+}
+
+func TestEnsureCorrelationIDExisting(t *testing.T) {
+	p := NewCorrelationIDProcessor(CorrelationIDProcessorWithGenerator(func() string {
+		t.Fatal("unexpected correlation ID generation")
+		return ""
+	}))
+
+	ctx := govevents.InjectCorrelationID(context.Background(), "existing")
+
+	got, cid, err := p.EnsureCorrelationID(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, "existing", cid)
+	assert.Equal(t, ctx, got)
+
+	// the existing ID is not registered
+	skip, _, err := p.WouldSkip(context.Background(), cid, govevents.GovernorEventUpdate, "groups")
+	require.NoError(t, err)
+	assert.False(t, skip)
+}
+
+func TestEnsureCorrelationIDRegisterError(t *testing.T) {
+	p := NewCorrelationIDProcessor(
+		CorrelationIDProcessorWithSelfOriginatedOnly(),
+		CorrelationIDProcessorWithHistoryCache(historyCacheOnly{historycache.NewLocalCache()}),
+		CorrelationIDProcessorWithGenerator(func() string { return "generated" }),
+	)
+
+	// the context carries the ID even if it could not be registered
+	ctx, cid, err := p.EnsureCorrelationID(context.Background())
+	require.ErrorIs(t, err, ErrHistoryCacheNotInspectable)
+
+	assert.Equal(t, "generated", cid)
+	assert.Equal(t, "generated", govevents.ExtractCorrelationID(ctx))
+}
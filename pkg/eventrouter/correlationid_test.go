@@ -0,0 +1,196 @@
+package eventrouter
+
+import (
+	"context"
+	"testing"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordWriteSelfOriginated(t *testing.T) {
+	tests := []struct {
+		name       string
+		resourceID string
+		event      govevents.Event
+		skip       bool
+	}{
+		{
+			name:  "write without resource ID",
+			event: govevents.Event{ExtensionResourceID: "resource-a"},
+			skip:  true,
+		},
+		{
+			name:       "extension resource",
+			resourceID: "resource-a",
+			event:      govevents.Event{ExtensionResourceID: "resource-a"},
+			skip:       true,
+		},
+		{
+			name:       "group",
+			resourceID: "group-a",
+			event:      govevents.Event{GroupID: "group-a"},
+			skip:       true,
+		},
+		{
+			name:       "user",
+			resourceID: "user-a",
+			event:      govevents.Event{GroupID: "group-a", UserID: "user-a"},
+			skip:       true,
+		},
+		{
+			name:       "application",
+			resourceID: "app-a",
+			event:      govevents.Event{GroupID: "group-a", ApplicationID: "app-a"},
+			skip:       true,
+		},
+		{
+			name:       "other resource",
+			resourceID: "resource-b",
+			event:      govevents.Event{ExtensionResourceID: "resource-a", GroupID: "group-a"},
+			skip:       false,
+		},
+		{
+			name:       "event without resource",
+			resourceID: "resource-a",
+			skip:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := NewCorrelationIDProcessor(CorrelationIDProcessorWithSelfOriginatedOnly())
+
+			require.NoError(t, p.RecordWrite(ctx, "cid", tt.resourceID))
+
+			event := tt.event
+			event.Action = govevents.GovernorEventUpdate
+			event.Headers = map[string][]string{
+				govevents.GovernorEventCorrelationIDHeader: {"cid"},
+			}
+
+			skip, _, err := p.ShouldSkipEvent(ctx, "groups", &event)
+			require.NoError(t, err)
+			assert.Equal(t, tt.skip, skip)
+
+			// other correlation IDs are not self-originated
+			event.Headers = map[string][]string{
+				govevents.GovernorEventCorrelationIDHeader: {"other"},
+			}
+
+			skip, _, err = p.ShouldSkipEvent(ctx, "groups", &event)
+			require.NoError(t, err)
+			assert.False(t, skip)
+		})
+	}
+}
+
+func TestRecordWriteNotSelfOriginated(t *testing.T) {
+	ctx := context.Background()
+	cache := historycache.NewLocalCache()
+
+	p := NewCorrelationIDProcessor(CorrelationIDProcessorWithHistoryCache(cache))
+
+	require.NoError(t, p.RecordWrite(ctx, "cid", "resource-a"))
+	require.NoError(t, p.RecordWrite(ctx, "cid", ""))
+
+	// nothing is recorded in the history cache
+	assert.Zero(t, cache.Len())
+
+	// writes are not recorded when self-originated events are not skipped, so
+	// caches that cannot be inspected are fine
+	p = NewCorrelationIDProcessor(CorrelationIDProcessorWithHistoryCache(historyCacheOnly{cache}))
+	require.NoError(t, p.RecordWrite(ctx, "cid", "resource-a"))
+}
+
+func TestShouldSkipEventRuleSets(t *testing.T) {
+	never := SkipRule{Name: "never-groups", Subjects: []string{"groups"}, Never: true}
+	updates := SkipRule{Name: "updates", Actions: []string{govevents.GovernorEventUpdate}}
+	actorAll := SkipRule{Name: "actor-all"}
+
+	tests := []struct {
+		name       string
+		rules      []SkipRule
+		actorRules []SkipRule
+		seen       bool
+		subj       string
+		skip       bool
+		rule       string
+	}{
+		{
+			name:  "seen correlation ID",
+			rules: []SkipRule{updates},
+			seen:  true,
+			subj:  "users",
+			skip:  true,
+			rule:  "updates",
+		},
+		{
+			name:       "self actor",
+			rules:      []SkipRule{updates},
+			actorRules: []SkipRule{actorAll},
+			subj:       "users",
+			skip:       true,
+			rule:       "actor-all",
+		},
+		{
+			name:       "never rule of seen correlation ID is final",
+			rules:      []SkipRule{never, updates},
+			actorRules: []SkipRule{actorAll},
+			seen:       true,
+			subj:       "groups",
+			skip:       false,
+			rule:       "never-groups",
+		},
+		{
+			name:       "never rule of self actor",
+			rules:      []SkipRule{updates},
+			actorRules: []SkipRule{never, actorAll},
+			seen:       true,
+			subj:       "groups",
+			skip:       true,
+			rule:       "updates",
+		},
+		{
+			name:       "unnamed never rule",
+			rules:      []SkipRule{{Subjects: []string{"groups"}, Never: true}},
+			actorRules: []SkipRule{actorAll},
+			seen:       true,
+			subj:       "groups",
+			skip:       false,
+			rule:       "rule-0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			p := NewCorrelationIDProcessor(
+				CorrelationIDProcessorWithSkipRules(tt.rules...),
+				CorrelationIDProcessorWithSelfActors("extension"),
+				CorrelationIDProcessorWithActorSkipRules(tt.actorRules...),
+			)
+
+			if tt.seen {
+				require.NoError(t, p.RegisterCorrelationID(ctx, "cid"))
+			}
+
+			event := &govevents.Event{
+				Action:  govevents.GovernorEventUpdate,
+				ActorID: "extension",
+				Headers: map[string][]string{
+					govevents.GovernorEventCorrelationIDHeader: {"cid"},
+				},
+			}
+
+			skip, rule, err := p.WouldSkipEvent(ctx, tt.subj, event)
+			require.NoError(t, err)
+			assert.Equal(t, tt.skip, skip)
+			assert.Equal(t, tt.rule, rule)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package eventrouter
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// CorrelationIDKey is the span attribute and baggage member key of the
+	// correlation ID
+	CorrelationIDKey = "governor.correlation_id"
+
+	// DefaultWriteSpanCacheSize is the default number of write spans kept to
+	// link the processing spans of the events they caused
+	DefaultWriteSpanCacheSize = 1000
+	// DefaultWriteSpanCacheTTL is the default duration a write span is kept
+	DefaultWriteSpanCacheTTL = 10 * time.Minute
+)
+
+// CorrelationIDProcessorWithWriteSpanCache sets the size and TTL of the cache
+// of the spans of the writes recorded with RecordWrite. The router configured
+// with the processor links the processing spans of the events to the spans of
+// the writes that caused them. A size of 0 disables the span links.
+func CorrelationIDProcessorWithWriteSpanCache(size int, ttl time.Duration) CorrelationIDProcessorOpt {
+	return func(p *CorrelationIDProcessor) {
+		if size <= 0 {
+			p.writeSpans = nil
+			return
+		}
+
+		p.writeSpans = expirable.NewLRU[string, trace.SpanContext](size, nil, ttl)
+	}
+}
+
+// ContextWithCorrelationID returns the context with the correlation ID added
+// as an OpenTelemetry baggage member, and sets it as an attribute of the
+// current span. Baggage members are propagated with the trace context of the
+// outgoing requests when the baggage propagator is configured.
+func ContextWithCorrelationID(ctx context.Context, cid string) context.Context {
+	if cid == "" {
+		return ctx
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(CorrelationIDKey, cid))
+
+	member, err := baggage.NewMember(CorrelationIDKey, cid)
+	if err != nil {
+		return ctx
+	}
+
+	bag, err := baggage.FromContext(ctx).SetMember(member)
+	if err != nil {
+		return ctx
+	}
+
+	return baggage.ContextWithBaggage(ctx, bag)
+}
+
+// recordWriteSpan records the span the write with the correlation ID was sent
+// from
+func (p *CorrelationIDProcessor) recordWriteSpan(ctx context.Context, cid string) {
+	if p.writeSpans == nil {
+		return
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		p.writeSpans.Add(cid, sc)
+	}
+}
+
+// writeSpanLink returns the link to the span of the write that caused the
+// event with the correlation ID, if it is known
+func (p *CorrelationIDProcessor) writeSpanLink(cid string) (trace.Link, bool) {
+	if p.writeSpans == nil || cid == "" {
+		return trace.Link{}, false
+	}
+
+	sc, ok := p.writeSpans.Get(cid)
+	if !ok {
+		return trace.Link{}, false
+	}
+
+	if ce := p.logger.Check(zap.DebugLevel, "linking span to originating write"); ce != nil {
+		ce.Write(
+			zap.String("correlation-id", cid),
+			zap.String("write-trace-id", sc.TraceID().String()),
+			zap.String("component", "correlation-id-middleware"),
+		)
+	}
+
+	return trace.Link{
+		SpanContext: sc,
+		Attributes:  []attribute.KeyValue{attribute.String(CorrelationIDKey, cid)},
+	}, true
+}
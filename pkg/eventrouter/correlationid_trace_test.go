@@ -0,0 +1,52 @@
+package eventrouter
+
+import (
+	"context"
+	"testing"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCorrelationIDSkipRecordedOnSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	// the tracer is configured before the correlation ID processor, the
+	// processing span still wraps the skip decision
+	r := NewRouter(
+		WithTracer(tp.Tracer("test")),
+		WithCorrelationIDProcessor(NewCorrelationIDProcessor()),
+	)
+	r.Update("groups", noopHandler)
+
+	assert.Equal(t, "trace-context", r.Routes()[0].GlobalMiddlewares[0])
+
+	event := &govevents.Event{
+		Action: govevents.GovernorEventUpdate,
+		Headers: map[string][]string{
+			govevents.GovernorEventCorrelationIDHeader: {"cid"},
+		},
+	}
+
+	require.NoError(t, r.Process(context.Background(), "groups", event))
+	require.NoError(t, r.Process(context.Background(), "groups", event))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	for _, span := range spans {
+		assert.Equal(t, "process-event", span.Name())
+		assert.Contains(t, span.Attributes(), attribute.String(CorrelationIDKey, "cid"))
+	}
+
+	assert.Empty(t, spans[0].Events())
+
+	require.Len(t, spans[1].Events(), 1)
+	assert.Equal(t, "event skipped", spans[1].Events()[0].Name)
+	assert.Contains(t, spans[1].Events()[0].Attributes, attribute.String("skip-rule", "update-only"))
+}
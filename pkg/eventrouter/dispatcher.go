@@ -0,0 +1,43 @@
+package eventrouter
+
+import (
+	"context"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+)
+
+// Dispatcher runs the events deferred by a middleware, e.g. held by the
+// Coalescer, outside of the Process call that received them, and lends the
+// workers of events waiting on other events, e.g. in the Batcher. The caller of the
+// router, e.g. the server, saves it in the context of the events with
+// SaveDispatcherToContext, so deferred events share its workers, tracking and
+// shutdown with the other events.
+type Dispatcher interface {
+	// Dispatch processes the deferred event with the handler and returns the
+	// handler's error. The context carries the metadata of the event and the
+	// span it was received with, which has ended already.
+	Dispatch(ctx context.Context, event *govevents.Event, handler Handler) error
+	// ReleaseWorker gives the worker of the calling event back while the
+	// event waits, e.g. for its batch, and returns a function taking the
+	// worker again. The function is called once the event stops waiting,
+	// which can be after the event returned when its timeout abandoned the
+	// handler, the worker must then not be taken again.
+	ReleaseWorker() (reacquire func())
+	// OnShutdown registers a function called when the dispatcher shuts down,
+	// e.g. to dispatch the held events
+	OnShutdown(fn func())
+}
+
+// SaveDispatcherToContext saves the dispatcher of deferred events to the
+// context
+func SaveDispatcherToContext(ctx context.Context, d Dispatcher) context.Context {
+	return context.WithValue(ctx, dispatcherCtxKey, d)
+}
+
+// GetDispatcherFromContext gets the dispatcher of deferred events from the
+// context, it returns nil if it is not set
+func GetDispatcherFromContext(ctx context.Context) Dispatcher {
+	d, _ := ctx.Value(dispatcherCtxKey).(Dispatcher)
+
+	return d
+}
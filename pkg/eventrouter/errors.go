@@ -2,7 +2,50 @@ package eventrouter
 
 import (
 	"errors"
+	"fmt"
 )
 
-// ErrHandlerNotFound is the error returned when a handler is not found
-var ErrHandlerNotFound = errors.New("handler not found")
+var (
+	// ErrHandlerNotFound is the error returned when a handler is not found
+	ErrHandlerNotFound = errors.New("handler not found")
+	// ErrHandlerPanic is the error returned when a handler panics
+	ErrHandlerPanic = errors.New("handler panicked")
+	// ErrHandlerTimeout is the error returned when a handler does not complete
+	// within its timeout
+	ErrHandlerTimeout = errors.New("handler timed out")
+	// ErrInvalidPredicate is the error returned when a filter expression
+	// cannot be parsed
+	ErrInvalidPredicate = errors.New("invalid predicate expression")
+	// ErrBatchResultMismatch is the error returned for events that a batch
+	// handler did not report a result for
+	ErrBatchResultMismatch = errors.New("batch handler result count mismatch")
+	// ErrHistoryCacheNotInspectable is the error returned when a history cache
+	// has to be inspected without recording the ID, but it does not implement
+	// historycache.KeyStore
+	ErrHistoryCacheNotInspectable = errors.New("history cache does not support lookups")
+	// ErrLoopDetected is the error reported when an event is dropped by the
+	// loop detector
+	ErrLoopDetected = errors.New("event loop detected")
+	// ErrEventInFlight is the error returned for a duplicate of an event that
+	// is still being processed, the duplicate should be redelivered later
+	ErrEventInFlight = errors.New("event is already being processed")
+)
+
+// PanicError is the error returned when a panic is recovered from a handler,
+// it wraps ErrHandlerPanic
+type PanicError struct {
+	// Value is the value the handler panicked with
+	Value any
+	// Stack is the stack trace of the goroutine at the time of the panic
+	Stack []byte
+}
+
+// Error implements the error interface
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrHandlerPanic, e.Value)
+}
+
+// Unwrap returns ErrHandlerPanic
+func (e *PanicError) Unwrap() error {
+	return ErrHandlerPanic
+}
@@ -21,3 +21,26 @@ type EventRouter interface {
 
 	Subjects() []string
 }
+
+// SubjectListener is notified when a subject is registered with, or removed
+// from, an event router at runtime. The notifications are delivered in the
+// order of the changes, one at a time, so a listener must not add or remove
+// routes itself.
+type SubjectListener interface {
+	// SubjectAdded is called after the first route for a subject is added
+	SubjectAdded(subj string)
+	// SubjectRemoved is called after the last route for a subject is removed
+	SubjectRemoved(subj string)
+}
+
+// SubjectNotifier is implemented by event routers that can notify listeners
+// about subject changes
+type SubjectNotifier interface {
+	AddSubjectListener(l SubjectListener)
+}
+
+// RouteRemover is implemented by event routers that can remove routes at
+// runtime
+type RouteRemover interface {
+	Remove(action, subj string)
+}
@@ -0,0 +1,86 @@
+package eventrouter
+
+import (
+	"context"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"go.uber.org/zap"
+)
+
+// EventFilter is responsible for filtering out events that handlers are not
+// interested in, before they reach the handlers.
+type EventFilter struct {
+	name      string
+	predicate Predicate
+	logger    *zap.Logger
+}
+
+// EventFilterOpt is a function type for configuring EventFilter.
+type EventFilterOpt func(*EventFilter)
+
+// NewEventFilter creates a new EventFilter, only events matching the predicate
+// are passed to the next handler.
+func NewEventFilter(pred Predicate, opts ...EventFilterOpt) *EventFilter {
+	f := &EventFilter{
+		name:      "default",
+		predicate: pred,
+		logger:    zap.NewNop(),
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// NewEventFilterFromExpression creates a new EventFilter from a filter
+// expression, see ParsePredicate for the expression syntax.
+func NewEventFilterFromExpression(expr string, opts ...EventFilterOpt) (*EventFilter, error) {
+	pred, err := ParsePredicate(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEventFilter(pred, opts...), nil
+}
+
+// EventFilterWithLogger sets the logger for EventFilter.
+func EventFilterWithLogger(logger *zap.Logger) EventFilterOpt {
+	return func(f *EventFilter) {
+		f.logger = logger
+	}
+}
+
+// EventFilterWithName sets the name for EventFilter, the name is used in logs
+// and metrics to tell filters apart.
+func EventFilterWithName(name string) EventFilterOpt {
+	return func(f *EventFilter) {
+		f.name = name
+	}
+}
+
+// MWFilter returns a middleware that only passes events matching the filter
+// predicate to the next handler, other events are dropped without error.
+func (f *EventFilter) MWFilter(next Handler) Handler {
+	return func(ctx context.Context, event *govevents.Event) error {
+		if f.predicate == nil || f.predicate(event) {
+			return next(ctx, event)
+		}
+
+		subj := GetSubjectFromContext(ctx)
+
+		f.logger.Debug(
+			"filtered event",
+			zap.String("filter", f.name),
+			zap.String("action", event.Action),
+			zap.String("subject", subj),
+			zap.String("resource-id", event.ExtensionResourceID),
+			zap.String("component", "filter-middleware"),
+		)
+
+		eventsFilteredTotal.WithLabelValues(f.name, subj, event.Action).Inc()
+
+		return nil
+	}
+}
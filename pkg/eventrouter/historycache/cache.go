@@ -5,6 +5,7 @@ package historycache
 
 import (
 	"context"
+	"time"
 
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
@@ -19,6 +20,38 @@ type HistoryCache interface {
 	Remove(ctx context.Context, id string) error
 }
 
+// KeyStore defines the interface for a cache that records keys once an
+// operation has completed, as opposed to HistoryCache which records IDs the
+// first time they are seen.
+type KeyStore interface {
+	// Exists checks if a key exists in the cache and has not expired.
+	Exists(ctx context.Context, id string) (bool, error)
+	// Store stores a key in the cache. The key expires after ttl or after the
+	// expiry of the cache itself, whichever comes first; a zero ttl only uses
+	// the expiry of the cache.
+	Store(ctx context.Context, id string, ttl time.Duration) error
+}
+
+// Purger defines the interface for a cache that can remove all of its IDs.
+type Purger interface {
+	// Purge removes all the IDs from the cache.
+	Purge(ctx context.Context) error
+}
+
+// expiresAt returns the expiry time for a ttl, zero means no expiry
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(ttl)
+}
+
+// expired reports whether an expiry time returned by expiresAt has passed
+func expired(exp time.Time) bool {
+	return !exp.IsZero() && time.Now().After(exp)
+}
+
 type configurable interface {
 	setLogger(l *zap.Logger)
 	setTracer(t trace.Tracer)
@@ -0,0 +1,121 @@
+package historycache_test
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache/historycachetest"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalCacheConformance(t *testing.T) {
+	historycachetest.Run(t, func(_ *testing.T, ttl time.Duration) historycache.HistoryCache {
+		return historycache.NewLocalCache(historycache.WithTTL(ttl))
+	})
+}
+
+func TestFileCacheConformance(t *testing.T) {
+	historycachetest.Run(t, func(t *testing.T, ttl time.Duration) historycache.HistoryCache {
+		fc, err := historycache.NewFileCache(filepath.Join(t.TempDir(), "history.db"), historycache.WithTTL(ttl))
+		require.NoError(t, err)
+
+		t.Cleanup(func() { _ = fc.Close() })
+
+		return fc
+	})
+}
+
+func TestNATSCacheConformance(t *testing.T) {
+	js := newJetStream(t)
+
+	historycachetest.Run(t, func(t *testing.T, ttl time.Duration) historycache.HistoryCache {
+		return newNATSCache(t, js, ttl)
+	})
+}
+
+func TestTieredCacheConformance(t *testing.T) {
+	js := newJetStream(t)
+
+	for _, consistency := range []historycache.Consistency{
+		historycache.ConsistencyLocalFirst,
+		historycache.ConsistencyAuthoritativeRemote,
+	} {
+		t.Run(consistency.String(), func(t *testing.T) {
+			historycachetest.Run(t, func(t *testing.T, ttl time.Duration) historycache.HistoryCache {
+				return historycache.NewTieredCache(
+					historycache.NewLocalCache(historycache.WithTTL(ttl)),
+					newNATSCache(t, js, ttl),
+					historycache.WithConsistency(consistency),
+				)
+			})
+		})
+	}
+}
+
+func TestNewNATSCacheFromConfigTTLMismatch(t *testing.T) {
+	js := newJetStream(t)
+
+	_, err := historycache.NewNATSCacheFromConfig(js, &nats.KeyValueConfig{Bucket: "mismatch", TTL: time.Minute})
+	require.NoError(t, err)
+
+	_, err = historycache.NewNATSCacheFromConfig(js, &nats.KeyValueConfig{Bucket: "mismatch", TTL: time.Minute})
+	require.NoError(t, err, "binding to a bucket with the same TTL must succeed")
+
+	_, err = historycache.NewNATSCacheFromConfig(js, &nats.KeyValueConfig{Bucket: "mismatch", TTL: time.Hour})
+	require.ErrorIs(t, err, historycache.ErrBucketTTLMismatch)
+}
+
+var bucketSeq atomic.Int64
+
+// newNATSCache creates a NATSCache backed by a new bucket
+func newNATSCache(t *testing.T, js nats.JetStreamContext, ttl time.Duration) *historycache.NATSCache {
+	t.Helper()
+
+	c, err := historycache.NewNATSCacheFromConfig(js, &nats.KeyValueConfig{
+		Bucket:  "history-" + strconv.FormatInt(bucketSeq.Add(1), 10),
+		TTL:     ttl,
+		Storage: nats.MemoryStorage,
+	})
+	require.NoError(t, err)
+
+	return c
+}
+
+// newJetStream starts an embedded NATS server with JetStream enabled
+func newJetStream(t *testing.T) nats.JetStreamContext {
+	t.Helper()
+
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+
+	go s.Start()
+
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("NATS server is not ready")
+	}
+
+	t.Cleanup(s.Shutdown)
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+
+	t.Cleanup(nc.Close)
+
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+
+	return js
+}
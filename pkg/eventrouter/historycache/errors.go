@@ -0,0 +1,18 @@
+package historycache
+
+import "errors"
+
+var (
+	// ErrBucketTTLMismatch is returned when an existing NATS Key-Value bucket
+	// has a different TTL than the configured one
+	ErrBucketTTLMismatch = errors.New("NATS bucket TTL does not match the configured TTL")
+	// ErrNotKeyStore is returned when a KeyStore operation is called on a cache
+	// whose backend does not implement KeyStore
+	ErrNotKeyStore = errors.New("cache backend does not implement KeyStore")
+	// ErrNotPurger is returned when Purge is called on a cache whose backend
+	// does not implement Purger
+	ErrNotPurger = errors.New("cache backend does not implement Purger")
+	// ErrFileCacheUnavailable is returned by a FileCache whose file could not
+	// be reopened after a compaction
+	ErrFileCacheUnavailable = errors.New("history cache file is unavailable")
+)
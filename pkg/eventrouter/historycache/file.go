@@ -0,0 +1,590 @@
+package historycache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultFileCacheTTL is the default time IDs are kept by a FileCache
+	DefaultFileCacheTTL = 10 * time.Minute
+	// DefaultFileCacheName is the default name of a FileCache in metrics
+	DefaultFileCacheName = "file"
+
+	fileCacheOpenTimeout = 5 * time.Second
+	// fileCacheCompactTxSize is the size of the transactions copying the IDs
+	// to the compacted file
+	fileCacheCompactTxSize = 64 * 1024
+)
+
+var fileCacheBucket = []byte("history")
+
+var (
+	_ HistoryCache = (*FileCache)(nil)
+	_ KeyStore     = (*FileCache)(nil)
+	_ Purger       = (*FileCache)(nil)
+	_ configurable = (*FileCache)(nil)
+)
+
+// FileCache is an on-disk implementation of the HistoryCache and KeyStore
+// interfaces backed by a bbolt database, for single replica extensions that
+// need to keep the history across restarts.
+//
+// Every write is committed to disk before it returns, so the history survives
+// crashes. Expired IDs are deleted periodically, the space they used is reused
+// by new IDs so the file does not grow past the working set of the cache.
+// The file never shrinks unless it is compacted, see WithCompactInterval.
+// The file is locked while it is open, so a FileCache cannot be shared by
+// multiple processes.
+type FileCache struct {
+	// mu guards db, which is replaced when the file is compacted
+	mu sync.RWMutex
+	db *bbolt.DB
+	// err is set when the file cannot be reopened after a compaction, db is
+	// then nil
+	err error
+	// open opens the cache file, it is replaced in tests
+	open   func(path string) (*bbolt.DB, error)
+	path   string
+	logger *zap.Logger
+	tracer trace.Tracer
+
+	ttl             time.Duration
+	sweepInterval   time.Duration
+	compactInterval time.Duration
+	name            string
+
+	// registerer is the registerer the metrics are registered with, nil
+	// disables the registration
+	registerer prometheus.Registerer
+	hits       prometheus.Counter
+	misses     prometheus.Counter
+	evictions  prometheus.Counter
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewFileCache opens or creates the cache file at path and starts deleting
+// expired IDs in the background. The cache must be closed with Close.
+func NewFileCache(path string, opts ...Opt) (*FileCache, error) {
+	fc := &FileCache{
+		logger: zap.NewNop(),
+		tracer: noop.NewTracerProvider().Tracer("file-cache"),
+		ttl:    DefaultFileCacheTTL,
+		name:   DefaultFileCacheName,
+		path:   path,
+		open:   openFileCacheDB,
+		stop:   make(chan struct{}),
+
+		registerer: prometheus.DefaultRegisterer,
+	}
+
+	for _, opt := range opts {
+		opt(fc)
+	}
+
+	if fc.sweepInterval <= 0 {
+		fc.sweepInterval = fc.ttl / 2 //nolint: mnd
+	}
+
+	db, err := openFileCacheDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(fileCacheBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize history cache file %s: %w", path, err)
+	}
+
+	if err := registerMetrics(fc.registerer); err != nil {
+		fc.logger.Warn("failed to register metrics", zap.Error(err))
+	}
+
+	fc.db = db
+	fc.hits = cacheHitsTotal.WithLabelValues(fc.name)
+	fc.misses = cacheMissesTotal.WithLabelValues(fc.name)
+	fc.evictions = cacheEvictionsTotal.WithLabelValues(fc.name)
+
+	fc.wg.Add(1)
+
+	go fc.sweepLoop()
+
+	return fc, nil
+}
+
+// openFileCacheDB opens the cache file at path
+func openFileCacheDB(path string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: fileCacheOpenTimeout}) //nolint: mnd
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history cache file %s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+// WithSweepInterval is an option to set how often a FileCache deletes expired
+// IDs, the default is half of the TTL. It has no effect on other HistoryCache
+// implementations.
+func WithSweepInterval(d time.Duration) Opt {
+	return func(c configurable) {
+		if fc, ok := c.(*FileCache); ok && d > 0 {
+			fc.sweepInterval = d
+		}
+	}
+}
+
+// WithCompactInterval is an option to set how often a FileCache compacts its
+// file, giving the space of the deleted IDs back to the file system. The file
+// is copied, so compacting needs as much free disk space as the live IDs use,
+// and the cache is blocked while it is copied. The default is to never compact.
+// It has no effect on other HistoryCache implementations.
+func WithCompactInterval(d time.Duration) Opt {
+	return func(c configurable) {
+		if fc, ok := c.(*FileCache); ok && d > 0 {
+			fc.compactInterval = d
+		}
+	}
+}
+
+// ExistsOrStore is an atomic operation that checks if a correlation ID exists in the cache;
+// if it does not exist, it stores the ID and returns false, otherwise returns true.
+func (fc *FileCache) ExistsOrStore(ctx context.Context, id string) (bool, error) {
+	_, span := fc.tracer.Start(ctx, "FileCache.ExistsOrStore")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	exists := false
+
+	// bbolt runs one write transaction at a time, which makes the check and
+	// the write atomic
+	err := fc.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(fileCacheBucket)
+
+		if exp, ok := decodeExpiry(b.Get([]byte(id))); ok && !expired(exp) {
+			exists = true
+			return nil
+		}
+
+		return b.Put([]byte(id), encodeExpiry(fc.expiresAt(0)))
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to update history cache file")
+		span.RecordError(err)
+
+		return false, err
+	}
+
+	fc.record(span, id, exists)
+
+	return exists, nil
+}
+
+// Exists checks if a key exists in the cache and has not expired.
+func (fc *FileCache) Exists(ctx context.Context, id string) (bool, error) {
+	_, span := fc.tracer.Start(ctx, "FileCache.Exists")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	exists := false
+
+	err := fc.view(func(tx *bbolt.Tx) error {
+		exp, ok := decodeExpiry(tx.Bucket(fileCacheBucket).Get([]byte(id)))
+		exists = ok && !expired(exp)
+
+		return nil
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to read history cache file")
+		span.RecordError(err)
+
+		return false, err
+	}
+
+	fc.record(span, id, exists)
+
+	return exists, nil
+}
+
+// Store stores a key in the cache, the key expires after ttl or after the TTL
+// of the cache, whichever comes first.
+func (fc *FileCache) Store(ctx context.Context, id string, ttl time.Duration) error {
+	_, span := fc.tracer.Start(ctx, "FileCache.Store")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("id", id))
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := fc.update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(fileCacheBucket).Put([]byte(id), encodeExpiry(fc.expiresAt(ttl)))
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to update history cache file")
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// Remove removes a correlation ID from the cache.
+func (fc *FileCache) Remove(ctx context.Context, id string) error {
+	_, span := fc.tracer.Start(ctx, "FileCache.Remove")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("id", id))
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fc.update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(fileCacheBucket).Delete([]byte(id))
+	})
+}
+
+// Purge removes all the IDs from the cache.
+func (fc *FileCache) Purge(ctx context.Context) error {
+	_, span := fc.tracer.Start(ctx, "FileCache.Purge")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := fc.update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(fileCacheBucket); err != nil {
+			return err
+		}
+
+		_, err := tx.CreateBucket(fileCacheBucket)
+
+		return err
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.RecordError(err)
+	}
+
+	return err
+}
+
+// Sweep deletes the expired IDs from the cache, it returns the number of
+// deleted IDs. It is called periodically in the background.
+func (fc *FileCache) Sweep() (int, error) {
+	deleted := 0
+
+	err := fc.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(fileCacheBucket)
+
+		// keys are collected first, deleting while iterating with a cursor
+		// skips keys
+		var keys [][]byte
+
+		if err := b.ForEach(func(k, v []byte) error {
+			if exp, ok := decodeExpiry(v); !ok || expired(exp) {
+				keys = append(keys, k)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		deleted = len(keys)
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	fc.evictions.Add(float64(deleted))
+
+	return deleted, nil
+}
+
+// Compact copies the IDs to a new file replacing the cache file, so the file
+// only uses the space of the IDs it holds. It returns the sizes of the file
+// before and after compacting. The cache is blocked while it is compacted.
+// When the compacted file cannot be opened the original file is restored, and
+// when no file can be reopened every later operation of the cache returns
+// ErrFileCacheUnavailable.
+func (fc *FileCache) Compact() (before, after int64, err error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.db == nil {
+		return 0, 0, fc.err
+	}
+
+	tmp := fc.path + ".compact"
+
+	// a leftover of a failed compaction is overwritten
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, 0, fmt.Errorf("failed to remove history cache file %s: %w", tmp, err)
+	}
+
+	dst, err := fc.open(tmp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := bbolt.Compact(dst, fc.db, fileCacheCompactTxSize); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmp)
+
+		return 0, 0, fmt.Errorf("failed to compact history cache file %s: %w", fc.path, err)
+	}
+
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, fmt.Errorf("failed to close history cache file %s: %w", tmp, err)
+	}
+
+	before, _ = fileSize(fc.path)
+
+	if err := fc.db.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, fmt.Errorf("failed to close history cache file %s: %w", fc.path, err)
+	}
+
+	// the original file is kept until the compacted file is open, so the
+	// cache can go back to it
+	backup := fc.path + ".orig"
+
+	if err := os.Rename(fc.path, backup); err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, fc.reopen(fmt.Errorf("failed to replace history cache file %s: %w", fc.path, err))
+	}
+
+	if err := os.Rename(tmp, fc.path); err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, fc.restore(backup, fmt.Errorf("failed to replace history cache file %s: %w", fc.path, err))
+	}
+
+	db, err := fc.open(fc.path)
+	if err != nil {
+		return 0, 0, fc.restore(backup, err)
+	}
+
+	fc.db = db
+
+	if err := os.Remove(backup); err != nil {
+		fc.logger.Warn("failed to remove the original history cache file", zap.String("path", backup), zap.Error(err))
+	}
+
+	after, _ = fileSize(fc.path)
+
+	return before, after, nil
+}
+
+// restore puts the original file back after a failed compaction and reopens
+// it, it returns the error of the compaction. It must be called with the lock
+// held.
+func (fc *FileCache) restore(backup string, cause error) error {
+	if err := os.Rename(backup, fc.path); err != nil {
+		return fc.fail(errors.Join(cause, fmt.Errorf("failed to restore history cache file %s: %w", fc.path, err)))
+	}
+
+	return fc.reopen(cause)
+}
+
+// reopen reopens the cache file after a failed compaction, it returns the
+// error of the compaction. It must be called with the lock held.
+func (fc *FileCache) reopen(cause error) error {
+	db, err := fc.open(fc.path)
+	if err != nil {
+		return fc.fail(errors.Join(cause, err))
+	}
+
+	fc.db = db
+
+	return cause
+}
+
+// fail marks the cache as failed once its file cannot be reopened, every
+// operation then returns ErrFileCacheUnavailable until the process restarts.
+// It must be called with the lock held.
+func (fc *FileCache) fail(err error) error {
+	fc.db = nil
+	fc.err = fmt.Errorf("%w: %w", ErrFileCacheUnavailable, err)
+
+	fc.logger.Error("history cache file is unavailable", zap.String("path", fc.path), zap.Error(err))
+
+	return fc.err
+}
+
+// Close stops the background sweep and closes the cache file.
+func (fc *FileCache) Close() error {
+	fc.once.Do(func() {
+		close(fc.stop)
+	})
+
+	fc.wg.Wait()
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.db == nil {
+		return nil
+	}
+
+	return fc.db.Close()
+}
+
+// update runs a read-write transaction on the cache file
+func (fc *FileCache) update(fn func(*bbolt.Tx) error) error {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	if fc.db == nil {
+		return fc.err
+	}
+
+	return fc.db.Update(fn)
+}
+
+// view runs a read-only transaction on the cache file
+func (fc *FileCache) view(fn func(*bbolt.Tx) error) error {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+
+	if fc.db == nil {
+		return fc.err
+	}
+
+	return fc.db.View(fn)
+}
+
+func (fc *FileCache) sweepLoop() {
+	defer fc.wg.Done()
+
+	ticker := time.NewTicker(fc.sweepInterval)
+	defer ticker.Stop()
+
+	// a nil channel never fires when compaction is disabled
+	var compact <-chan time.Time
+
+	if fc.compactInterval > 0 {
+		compactTicker := time.NewTicker(fc.compactInterval)
+		defer compactTicker.Stop()
+
+		compact = compactTicker.C
+	}
+
+	for {
+		select {
+		case <-fc.stop:
+			return
+		case <-ticker.C:
+			deleted, err := fc.Sweep()
+			if err != nil {
+				fc.logger.Error("failed to delete expired IDs", zap.Error(err))
+				continue
+			}
+
+			fc.logger.Debug("deleted expired IDs", zap.Int("deleted", deleted))
+		case <-compact:
+			before, after, err := fc.Compact()
+			if err != nil {
+				fc.logger.Error("failed to compact history cache file", zap.Error(err))
+				continue
+			}
+
+			fc.logger.Debug("compacted history cache file", zap.Int64("size-before", before), zap.Int64("size-after", after))
+		}
+	}
+}
+
+// fileSize returns the size of the file at path
+func fileSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// expiresAt returns the expiry of an ID stored with ttl, capped by the TTL of
+// the cache
+func (fc *FileCache) expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 || ttl > fc.ttl {
+		ttl = fc.ttl
+	}
+
+	return time.Now().Add(ttl)
+}
+
+// record updates the hit and miss counters and the span of a lookup
+func (fc *FileCache) record(span trace.Span, id string, exists bool) {
+	if exists {
+		fc.hits.Inc()
+	} else {
+		fc.misses.Inc()
+	}
+
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("id", id), attribute.Bool("exists", exists))
+	}
+
+	if ce := fc.logger.Check(zap.DebugLevel, "lookup"); ce != nil {
+		ce.Write(zap.String("id", id), zap.Bool("exists", exists))
+	}
+}
+
+func (fc *FileCache) setLogger(l *zap.Logger) {
+	fc.logger = l.With(zap.String("component", "file_cache"))
+}
+
+func (fc *FileCache) setTracer(t trace.Tracer) {
+	fc.tracer = t
+}
+
+// encodeExpiry encodes an expiry time as the big endian unix nanoseconds
+func encodeExpiry(exp time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(exp.UnixNano())) //nolint: gosec
+}
+
+// decodeExpiry decodes an expiry time encoded by encodeExpiry, it returns
+// false if the value is missing or malformed
+func decodeExpiry(v []byte) (time.Time, bool) {
+	if len(v) != 8 { //nolint: mnd
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), true //nolint: gosec
+}
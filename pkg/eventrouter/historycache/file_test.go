@@ -0,0 +1,212 @@
+package historycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestFileCachePersistsAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	fc, err := NewFileCache(path)
+	require.NoError(t, err)
+
+	exists, err := fc.ExistsOrStore(ctx, "cid")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, fc.Close())
+
+	fc, err = NewFileCache(path)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = fc.Close() })
+
+	exists, err = fc.ExistsOrStore(ctx, "cid")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestFileCacheSweep(t *testing.T) {
+	ctx := context.Background()
+
+	fc, err := NewFileCache(
+		filepath.Join(t.TempDir(), "history.db"),
+		WithTTL(time.Hour),
+		WithSweepInterval(time.Hour),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = fc.Close() })
+
+	require.NoError(t, fc.Store(ctx, "short-1", time.Millisecond))
+	require.NoError(t, fc.Store(ctx, "short-2", time.Millisecond))
+	require.NoError(t, fc.Store(ctx, "long", time.Minute))
+
+	time.Sleep(5 * time.Millisecond)
+
+	deleted, err := fc.Sweep()
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	exists, err := fc.Exists(ctx, "long")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestFileCacheCompact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	fc, err := NewFileCache(path, WithTTL(time.Hour), WithSweepInterval(time.Hour))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = fc.Close() })
+
+	storeExpired(t, fc, 5000) //nolint: mnd
+
+	require.NoError(t, fc.Store(ctx, "long", time.Minute))
+
+	_, err = fc.Sweep()
+	require.NoError(t, err)
+
+	// the space of the deleted IDs is kept until the file is compacted
+	grown, err := fileSize(path)
+	require.NoError(t, err)
+
+	before, after, err := fc.Compact()
+	require.NoError(t, err)
+
+	assert.Equal(t, grown, before)
+	assert.Less(t, after, before)
+
+	size, err := fileSize(path)
+	require.NoError(t, err)
+	assert.Equal(t, after, size)
+
+	// the cache keeps working with the compacted file
+	exists, err := fc.Exists(ctx, "long")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = fc.ExistsOrStore(ctx, "cid")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, fc.Close())
+
+	fc, err = NewFileCache(path)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = fc.Close() })
+
+	exists, err = fc.Exists(ctx, "cid")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestFileCacheCompactInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	fc, err := NewFileCache(path, WithTTL(time.Hour), WithSweepInterval(time.Millisecond), WithCompactInterval(10*time.Millisecond))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = fc.Close() })
+
+	storeExpired(t, fc, 5000) //nolint: mnd
+
+	grown, err := fileSize(path)
+	require.NoError(t, err)
+
+	// the expired IDs are deleted and the file compacted in the background
+	require.Eventually(t, func() bool {
+		size, err := fileSize(path)
+		return err == nil && size < grown
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+var errOpen = errors.New("open failed")
+
+// failOpen makes the nth open of a file by the cache fail when fail returns
+// true for n, counting from 1
+func failOpen(fc *FileCache, fail func(n int) bool) {
+	opens := 0
+
+	fc.open = func(path string) (*bbolt.DB, error) {
+		if opens++; fail(opens) {
+			return nil, errOpen
+		}
+
+		return openFileCacheDB(path)
+	}
+}
+
+func TestFileCacheCompactOpenFailure(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	fc, err := NewFileCache(path, WithTTL(time.Hour), WithSweepInterval(time.Hour))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = fc.Close() })
+
+	require.NoError(t, fc.Store(ctx, "cid", 0))
+
+	// the compacted file cannot be opened, the original file is restored
+	failOpen(fc, func(n int) bool { return n == 2 }) //nolint: mnd
+
+	_, _, err = fc.Compact()
+	require.ErrorIs(t, err, errOpen)
+	assert.NotErrorIs(t, err, ErrFileCacheUnavailable)
+
+	exists, err := fc.Exists(ctx, "cid")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = os.Stat(path + ".orig")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// no file can be reopened, the cache reports it
+	failOpen(fc, func(n int) bool { return n > 1 })
+
+	_, _, err = fc.Compact()
+	require.ErrorIs(t, err, ErrFileCacheUnavailable)
+
+	_, err = fc.ExistsOrStore(ctx, "other")
+	require.ErrorIs(t, err, ErrFileCacheUnavailable)
+
+	_, _, err = fc.Compact()
+	require.ErrorIs(t, err, ErrFileCacheUnavailable)
+
+	require.NoError(t, fc.Close())
+}
+
+// storeExpired stores n expired IDs in a single transaction
+func storeExpired(t *testing.T, fc *FileCache, n int) {
+	t.Helper()
+
+	err := fc.update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(fileCacheBucket)
+
+		for i := range n {
+			id := fmt.Sprintf("expired-%05d-%s", i, strings.Repeat("x", 100)) //nolint: mnd
+			if err := b.Put([]byte(id), encodeExpiry(time.Now().Add(-time.Minute))); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+}
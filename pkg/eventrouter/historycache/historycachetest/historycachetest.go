@@ -0,0 +1,242 @@
+// Package historycachetest provides a conformance test suite for
+// historycache.HistoryCache implementations.
+package historycachetest
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// DefaultTTL is the TTL of the caches created by the suite, it is long
+	// enough for expiry to be observed with backends expiring entries lazily
+	DefaultTTL = time.Second
+
+	concurrency = 32
+)
+
+// Factory creates a new, empty cache whose IDs expire after ttl. Resources
+// held by the cache should be released with t.Cleanup.
+type Factory func(t *testing.T, ttl time.Duration) historycache.HistoryCache
+
+// Run runs the conformance test suite against the caches created by factory.
+// Caches implementing historycache.KeyStore or historycache.Purger are also
+// tested against their contracts.
+func Run(t *testing.T, factory Factory) {
+	t.Helper()
+
+	t.Run("ExistsOrStore", func(t *testing.T) {
+		testExistsOrStore(t, factory(t, DefaultTTL))
+	})
+
+	t.Run("ExistsOrStoreIsAtomic", func(t *testing.T) {
+		testExistsOrStoreIsAtomic(t, factory(t, DefaultTTL))
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		testExpiry(t, factory(t, DefaultTTL))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		testRemove(t, factory(t, DefaultTTL))
+	})
+
+	t.Run("RemoveMissing", func(t *testing.T) {
+		testRemoveMissing(t, factory(t, DefaultTTL))
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		testContextCanceled(t, factory(t, DefaultTTL))
+	})
+
+	t.Run("KeyStore", func(t *testing.T) {
+		ks, ok := factory(t, DefaultTTL).(historycache.KeyStore)
+		if !ok {
+			t.Skip("cache does not implement KeyStore")
+		}
+
+		testKeyStore(t, ks)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		c := factory(t, DefaultTTL)
+
+		p, ok := c.(historycache.Purger)
+		if !ok {
+			t.Skip("cache does not implement Purger")
+		}
+
+		testPurge(t, c, p)
+	})
+}
+
+func testExistsOrStore(t *testing.T, c historycache.HistoryCache) {
+	ctx := context.Background()
+
+	exists, err := c.ExistsOrStore(ctx, "cid-1")
+	require.NoError(t, err)
+	assert.False(t, exists, "first call must report the ID as new")
+
+	exists, err = c.ExistsOrStore(ctx, "cid-1")
+	require.NoError(t, err)
+	assert.True(t, exists, "second call must report the ID as existing")
+
+	exists, err = c.ExistsOrStore(ctx, "cid-2")
+	require.NoError(t, err)
+	assert.False(t, exists, "IDs must not affect each other")
+}
+
+func testExistsOrStoreIsAtomic(t *testing.T, c historycache.HistoryCache) {
+	ctx := context.Background()
+
+	for i := range 5 {
+		id := "cid-" + strconv.Itoa(i)
+
+		var (
+			wg    sync.WaitGroup
+			start = make(chan struct{})
+			news  atomic.Int32
+			errs  atomic.Int32
+		)
+
+		for range concurrency {
+			wg.Go(func() {
+				<-start
+
+				exists, err := c.ExistsOrStore(ctx, id)
+				if err != nil {
+					errs.Add(1)
+					return
+				}
+
+				if !exists {
+					news.Add(1)
+				}
+			})
+		}
+
+		close(start)
+		wg.Wait()
+
+		require.Zero(t, errs.Load(), "concurrent calls must not fail")
+		assert.Equal(t, int32(1), news.Load(), "exactly one concurrent call must report the ID as new")
+	}
+}
+
+func testExpiry(t *testing.T, c historycache.HistoryCache) {
+	ctx := context.Background()
+
+	exists, err := c.ExistsOrStore(ctx, "cid")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	if ks, ok := c.(historycache.KeyStore); ok {
+		assert.Eventually(t, func() bool {
+			exists, err := ks.Exists(ctx, "cid")
+			return err == nil && !exists
+		}, 5*DefaultTTL, DefaultTTL/10, "the ID must expire after the TTL") //nolint: mnd
+
+		return
+	}
+
+	time.Sleep(2 * DefaultTTL) //nolint: mnd
+
+	exists, err = c.ExistsOrStore(ctx, "cid")
+	require.NoError(t, err)
+	assert.False(t, exists, "the ID must expire after the TTL")
+}
+
+func testRemove(t *testing.T, c historycache.HistoryCache) {
+	ctx := context.Background()
+
+	_, err := c.ExistsOrStore(ctx, "cid")
+	require.NoError(t, err)
+
+	require.NoError(t, c.Remove(ctx, "cid"))
+
+	exists, err := c.ExistsOrStore(ctx, "cid")
+	require.NoError(t, err)
+	assert.False(t, exists, "a removed ID must be reported as new")
+
+	exists, err = c.ExistsOrStore(ctx, "cid")
+	require.NoError(t, err)
+	assert.True(t, exists, "a removed ID must be stored again")
+}
+
+func testRemoveMissing(t *testing.T, c historycache.HistoryCache) {
+	assert.NoError(t, c.Remove(context.Background(), "missing"), "removing a missing ID must not fail")
+}
+
+func testContextCanceled(t *testing.T, c historycache.HistoryCache) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.ExistsOrStore(ctx, "cid")
+	require.ErrorIs(t, err, context.Canceled, "a canceled context must be reported")
+
+	exists, err := c.ExistsOrStore(context.Background(), "cid")
+	require.NoError(t, err)
+	assert.False(t, exists, "an ID must not be stored with a canceled context")
+
+	assert.ErrorIs(t, c.Remove(ctx, "cid"), context.Canceled, "a canceled context must be reported")
+}
+
+func testKeyStore(t *testing.T, ks historycache.KeyStore) {
+	ctx := context.Background()
+
+	exists, err := ks.Exists(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, exists, "a missing key must not exist")
+
+	require.NoError(t, ks.Store(ctx, "key", 0))
+
+	exists, err = ks.Exists(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, exists, "a stored key must exist")
+
+	require.NoError(t, ks.Store(ctx, "short", DefaultTTL/10)) //nolint: mnd
+
+	assert.Eventually(t, func() bool {
+		exists, err := ks.Exists(ctx, "short")
+		return err == nil && !exists
+	}, DefaultTTL, DefaultTTL/20, "a key must expire after its own TTL") //nolint: mnd
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = ks.Exists(canceled, "key")
+	assert.ErrorIs(t, err, context.Canceled, "a canceled context must be reported")
+	assert.ErrorIs(t, ks.Store(canceled, "key", 0), context.Canceled, "a canceled context must be reported")
+}
+
+func testPurge(t *testing.T, c historycache.HistoryCache, p historycache.Purger) {
+	ctx := context.Background()
+
+	require.NoError(t, p.Purge(ctx), "purging an empty cache must not fail")
+
+	for _, id := range []string{"a", "b", "c"} {
+		_, err := c.ExistsOrStore(ctx, id)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, p.Purge(ctx))
+
+	for _, id := range []string{"a", "b", "c"} {
+		exists, err := c.ExistsOrStore(ctx, id)
+		require.NoError(t, err)
+		assert.False(t, exists, "a purged ID must be reported as new")
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, p.Purge(canceled), context.Canceled, "a canceled context must be reported")
+}
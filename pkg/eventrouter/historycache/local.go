@@ -3,68 +3,317 @@ package historycache
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
 )
 
 const (
-	defaultCacheSize = 128
-	defaultTTL       = 1 * time.Minute
+	// DefaultLocalCacheSize is the default maximum number of IDs kept by a
+	// LocalCache
+	DefaultLocalCacheSize = 128
+	// DefaultLocalCacheTTL is the default time IDs are kept by a LocalCache
+	DefaultLocalCacheTTL = time.Minute
+	// DefaultLocalCacheName is the default name of a LocalCache in metrics
+	DefaultLocalCacheName = "local"
 )
 
 var (
 	_ HistoryCache = (*LocalCache)(nil)
+	_ KeyStore     = (*LocalCache)(nil)
+	_ Purger       = (*LocalCache)(nil)
 	_ configurable = (*LocalCache)(nil)
 )
 
-// LocalCache is an in-memory implementation of the HistoryCache interface.
+// EvictionCallback is called with the ID evicted from a LocalCache, either
+// because the cache is full or because the ID expired. It is not called for
+// IDs removed with Remove. The callback is called while the cache is locked and
+// must not call back into the cache.
+type EvictionCallback func(id string)
+
+// LocalCache is an in-memory implementation of the HistoryCache and KeyStore
+// interfaces.
 type LocalCache struct {
-	cache  *expirable.LRU[string, struct{}]
+	// cache maps IDs to their expiry time, a zero time means the entry only
+	// expires with the TTL of the cache
+	cache  *expirable.LRU[string, time.Time]
 	logger *zap.Logger
 	tracer trace.Tracer
 	mu     *sync.Mutex
+
+	size    int
+	ttl     time.Duration
+	name    string
+	onEvict EvictionCallback
+	// removing holds the IDs being removed with Remove, so they are not
+	// reported as evictions
+	removing sync.Map
+	// purging is set while the cache is purged, so the IDs are not reported as
+	// evictions
+	purging atomic.Bool
+
+	// registerer is the registerer the metrics are registered with, nil
+	// disables the registration
+	registerer prometheus.Registerer
+	hits       prometheus.Counter
+	misses     prometheus.Counter
+	evictions  prometheus.Counter
 }
 
 // NewLocalCache creates a new instance of LocalCache.
 func NewLocalCache(opts ...Opt) *LocalCache {
 	lc := &LocalCache{
-		cache: expirable.NewLRU[string, struct{}](defaultCacheSize, nil, defaultTTL),
-		mu:    &sync.Mutex{},
+		logger: zap.NewNop(),
+		tracer: noop.NewTracerProvider().Tracer("local-cache"),
+		mu:     &sync.Mutex{},
+		size:   DefaultLocalCacheSize,
+		ttl:    DefaultLocalCacheTTL,
+		name:   DefaultLocalCacheName,
+
+		registerer: prometheus.DefaultRegisterer,
 	}
 
 	for _, opt := range opts {
 		opt(lc)
 	}
 
+	if err := registerMetrics(lc.registerer); err != nil {
+		lc.logger.Warn("failed to register metrics", zap.Error(err))
+	}
+
+	lc.hits = cacheHitsTotal.WithLabelValues(lc.name)
+	lc.misses = cacheMissesTotal.WithLabelValues(lc.name)
+	lc.evictions = cacheEvictionsTotal.WithLabelValues(lc.name)
+	lc.cache = expirable.NewLRU(lc.size, lc.evicted, lc.ttl)
+
 	return lc
 }
 
+// WithSize is an option to set the maximum number of IDs kept by a
+// LocalCache, the least recently used ID is evicted once the cache is full.
+// It has no effect on other HistoryCache implementations.
+func WithSize(size int) Opt {
+	return func(c configurable) {
+		if lc, ok := c.(*LocalCache); ok && size > 0 {
+			lc.size = size
+		}
+	}
+}
+
+// WithTTL is an option to set the time IDs are kept by a LocalCache or a
+// FileCache. It has no effect on other HistoryCache implementations, which
+// rely on the expiry of their backing store.
+func WithTTL(ttl time.Duration) Opt {
+	return func(c configurable) {
+		if ttl <= 0 {
+			return
+		}
+
+		switch cc := c.(type) {
+		case *LocalCache:
+			cc.ttl = ttl
+		case *FileCache:
+			cc.ttl = ttl
+		}
+	}
+}
+
+// WithEvictionCallback is an option to set a callback called when an ID is
+// evicted from a LocalCache. It has no effect on other HistoryCache
+// implementations.
+func WithEvictionCallback(fn EvictionCallback) Opt {
+	return func(c configurable) {
+		if lc, ok := c.(*LocalCache); ok {
+			lc.onEvict = fn
+		}
+	}
+}
+
+// WithMetricsRegisterer is an option to set the registerer the metrics of a
+// LocalCache or a FileCache are registered with, the default is
+// prometheus.DefaultRegisterer. A nil registerer disables the registration.
+// It has no effect on other HistoryCache implementations.
+func WithMetricsRegisterer(reg prometheus.Registerer) Opt {
+	return func(c configurable) {
+		switch cc := c.(type) {
+		case *LocalCache:
+			cc.registerer = reg
+		case *FileCache:
+			cc.registerer = reg
+		}
+	}
+}
+
+// WithName is an option to set the name of a LocalCache or a FileCache used
+// to label its metrics, this is useful when an extension uses more than one
+// cache. It has no effect on other HistoryCache implementations.
+func WithName(name string) Opt {
+	return func(c configurable) {
+		if name == "" {
+			return
+		}
+
+		switch cc := c.(type) {
+		case *LocalCache:
+			cc.name = name
+		case *FileCache:
+			cc.name = name
+		}
+	}
+}
+
 // ExistsOrStore is an atomic operation that checks if a correlation ID exists in the cache;
 // if it does not exist, it stores the ID and returns false, otherwise returns true.
-func (lc *LocalCache) ExistsOrStore(_ context.Context, id string) (bool, error) {
+func (lc *LocalCache) ExistsOrStore(ctx context.Context, id string) (bool, error) {
+	_, span := lc.tracer.Start(ctx, "LocalCache.ExistsOrStore")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	lc.mu.Lock()
-	defer lc.mu.Unlock()
 
-	_, exists := lc.cache.Get(id)
+	exp, exists := lc.cache.Get(id)
+	if exists && expired(exp) {
+		exists = false
+	}
+
 	if !exists {
-		lc.cache.Add(id, struct{}{})
+		lc.cache.Add(id, time.Time{})
 	}
 
+	lc.mu.Unlock()
+
+	lc.record(span, id, exists)
+
 	return exists, nil
 }
 
+// Exists checks if a key exists in the cache and has not expired.
+func (lc *LocalCache) Exists(ctx context.Context, id string) (bool, error) {
+	_, span := lc.tracer.Start(ctx, "LocalCache.Exists")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	lc.mu.Lock()
+	exp, exists := lc.cache.Get(id)
+	lc.mu.Unlock()
+
+	exists = exists && !expired(exp)
+
+	lc.record(span, id, exists)
+
+	return exists, nil
+}
+
+// Store stores a key in the cache, the key expires after ttl or after the TTL
+// of the cache, whichever comes first.
+func (lc *LocalCache) Store(ctx context.Context, id string, ttl time.Duration) error {
+	_, span := lc.tracer.Start(ctx, "LocalCache.Store")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("id", id))
+	}
+
+	lc.mu.Lock()
+	lc.cache.Add(id, expiresAt(ttl))
+	lc.mu.Unlock()
+
+	return nil
+}
+
 // Remove removes a correlation ID from the cache.
-func (lc *LocalCache) Remove(_ context.Context, id string) error {
+func (lc *LocalCache) Remove(ctx context.Context, id string) error {
+	_, span := lc.tracer.Start(ctx, "LocalCache.Remove")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("id", id))
+	}
+
 	lc.mu.Lock()
+	lc.removing.Store(id, struct{}{})
 	lc.cache.Remove(id)
+	lc.removing.Delete(id)
 	lc.mu.Unlock()
 
 	return nil
 }
 
+// Purge removes all the IDs from the cache, they are not reported as
+// evictions.
+func (lc *LocalCache) Purge(ctx context.Context) error {
+	_, span := lc.tracer.Start(ctx, "LocalCache.Purge")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	lc.mu.Lock()
+	lc.purging.Store(true)
+	lc.cache.Purge()
+	lc.purging.Store(false)
+	lc.mu.Unlock()
+
+	return nil
+}
+
+// Len returns the number of IDs in the cache, including expired IDs that
+// have not been evicted yet.
+func (lc *LocalCache) Len() int {
+	return lc.cache.Len()
+}
+
+// record updates the hit and miss counters and the span of a lookup
+func (lc *LocalCache) record(span trace.Span, id string, exists bool) {
+	if exists {
+		lc.hits.Inc()
+	} else {
+		lc.misses.Inc()
+	}
+
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("id", id), attribute.Bool("exists", exists))
+	}
+
+	if ce := lc.logger.Check(zap.DebugLevel, "lookup"); ce != nil {
+		ce.Write(zap.String("id", id), zap.Bool("exists", exists))
+	}
+}
+
+// evicted is called by the LRU when an ID is removed
+func (lc *LocalCache) evicted(id string, _ time.Time) {
+	if _, ok := lc.removing.Load(id); ok || lc.purging.Load() {
+		return
+	}
+
+	lc.evictions.Inc()
+
+	if lc.onEvict != nil {
+		lc.onEvict(id)
+	}
+}
+
 func (lc *LocalCache) setLogger(l *zap.Logger) {
 	lc.logger = l.With(zap.String("component", "local_cache"))
 }
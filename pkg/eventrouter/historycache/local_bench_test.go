@@ -0,0 +1,78 @@
+package historycache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func BenchmarkLocalCacheExistsOrStoreHit(b *testing.B) {
+	lc := NewLocalCache()
+	ctx := context.Background()
+
+	if _, err := lc.ExistsOrStore(ctx, "cid"); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if _, err := lc.ExistsOrStore(ctx, "cid"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLocalCacheExistsOrStoreMiss(b *testing.B) {
+	lc := NewLocalCache()
+	ctx := context.Background()
+
+	// every ID is unique, so each call misses and stores the ID
+	ids := make([]string, b.N)
+	for i := range ids {
+		ids[i] = "cid-" + strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := range b.N {
+		if _, err := lc.ExistsOrStore(ctx, ids[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLocalCacheExists(b *testing.B) {
+	lc := NewLocalCache()
+	ctx := context.Background()
+
+	if err := lc.Store(ctx, "key", time.Minute); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if _, err := lc.Exists(ctx, "key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLocalCacheParallel(b *testing.B) {
+	lc := NewLocalCache()
+	ctx := context.Background()
+
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := lc.ExistsOrStore(ctx, "cid"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package historycache
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "governor_extension"
+	metricsSubsystem = "historycache"
+)
+
+var (
+	cacheHitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "hits_total",
+			Help:      "Total number of lookups that found the ID in the cache.",
+		},
+		[]string{"cache"},
+	)
+
+	cacheMissesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "misses_total",
+			Help:      "Total number of lookups that did not find the ID in the cache.",
+		},
+		[]string{"cache"},
+	)
+
+	cacheEvictionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "evictions_total",
+			Help:      "Total number of IDs evicted from the cache because it was full or the ID expired.",
+		},
+		[]string{"cache"},
+	)
+)
+
+// metrics are the collectors of the package
+var metrics = []prometheus.Collector{
+	cacheHitsTotal,
+	cacheMissesTotal,
+	cacheEvictionsTotal,
+}
+
+var (
+	metricsMu sync.Mutex
+	// registered holds the registerers the metrics are registered with
+	registered = make(map[prometheus.Registerer]struct{})
+)
+
+// registerMetrics registers the metrics with reg the first time a LocalCache or FileCache is
+// created with it. Metrics already registered by another copy of the package
+// are skipped, other registration errors are returned instead of panicking.
+func registerMetrics(reg prometheus.Registerer) error {
+	if reg == nil {
+		return nil
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	if _, ok := registered[reg]; ok {
+		return nil
+	}
+
+	registered[reg] = struct{}{}
+
+	var errs []error
+
+	for _, c := range metrics {
+		if err := reg.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if errors.As(err, &are) {
+				continue
+			}
+
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,64 @@
+package historycache_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMetricsRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	lc := historycache.NewLocalCache(
+		historycache.WithName("metrics-local"),
+		historycache.WithMetricsRegisterer(reg),
+	)
+
+	fc, err := historycache.NewFileCache(
+		filepath.Join(t.TempDir(), "history.db"),
+		historycache.WithName("metrics-file"),
+		historycache.WithMetricsRegisterer(reg),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = fc.Close() })
+
+	for _, c := range []historycache.HistoryCache{lc, fc} {
+		_, err := c.ExistsOrStore(context.Background(), "id")
+		require.NoError(t, err)
+
+		_, err = c.ExistsOrStore(context.Background(), "id")
+		require.NoError(t, err)
+	}
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	caches := make(map[string][]string)
+
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				caches[f.GetName()] = append(caches[f.GetName()], l.GetValue())
+			}
+		}
+	}
+
+	// both caches are counted by the metrics registered once with reg
+	for _, name := range []string{
+		"governor_extension_historycache_hits_total",
+		"governor_extension_historycache_misses_total",
+	} {
+		assert.Subset(t, caches[name], []string{"metrics-local", "metrics-file"}, name)
+	}
+
+	// a nil registerer disables the registration
+	assert.NotPanics(t, func() {
+		historycache.NewLocalCache(historycache.WithMetricsRegisterer(nil))
+	})
+}
@@ -3,6 +3,8 @@ package historycache
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"go.opentelemetry.io/otel/attribute"
@@ -14,6 +16,8 @@ import (
 
 var (
 	_ HistoryCache = (*NATSCache)(nil)
+	_ KeyStore     = (*NATSCache)(nil)
+	_ Purger       = (*NATSCache)(nil)
 	_ configurable = (*NATSCache)(nil)
 )
 
@@ -39,6 +43,34 @@ func NewNATSCache(kv nats.KeyValue, opts ...Opt) *NATSCache {
 	return c
 }
 
+// NewNATSCacheFromConfig creates a new instance of NATSCache backed by the
+// bucket described by cfg. The bucket is created if it does not exist,
+// otherwise the existing bucket is used as long as its TTL matches cfg.TTL.
+func NewNATSCacheFromConfig(kvm nats.KeyValueManager, cfg *nats.KeyValueConfig, opts ...Opt) (*NATSCache, error) {
+	kv, err := kvm.KeyValue(cfg.Bucket)
+
+	switch {
+	case errors.Is(err, nats.ErrBucketNotFound):
+		kv, err = kvm.CreateKeyValue(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create NATS bucket %s: %w", cfg.Bucket, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to bind NATS bucket %s: %w", cfg.Bucket, err)
+	default:
+		status, err := kv.Status()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get NATS bucket %s status: %w", cfg.Bucket, err)
+		}
+
+		if status.TTL() != cfg.TTL {
+			return nil, fmt.Errorf("%w: bucket %s has TTL %s, expected %s", ErrBucketTTLMismatch, cfg.Bucket, status.TTL(), cfg.TTL)
+		}
+	}
+
+	return NewNATSCache(kv, opts...), nil
+}
+
 // ExistsOrStore is an atomic operation that checks if a correlation ID exists in the cache;
 // if it does not exist, it stores the ID and returns false, otherwise returns true.
 // here a NATS create operation is used as a mutex, if multiple concurrent requests
@@ -49,6 +81,12 @@ func (c *NATSCache) ExistsOrStore(ctx context.Context, id string) (bool, error)
 	_, span := c.tracer.Start(ctx, "NATSCache.ExistsOrStore")
 	defer span.End()
 
+	// the NATS Key-Value API does not take a context, so it is only checked
+	// before the request
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	exists := false
 
 	if _, err := c.kv.Create(id, []byte{}); err != nil {
@@ -73,11 +111,115 @@ func (c *NATSCache) Remove(ctx context.Context, id string) error {
 	_, span := c.tracer.Start(ctx, "NATSCache.Remove")
 	defer span.End()
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	span.SetAttributes(attribute.String("id", id))
 
 	return c.kv.Delete(id)
 }
 
+// Purge removes all the IDs from the cache.
+func (c *NATSCache) Purge(ctx context.Context) error {
+	_, span := c.tracer.Start(ctx, "NATSCache.Purge")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	keys, err := c.kv.Keys()
+	if err != nil {
+		if errors.Is(err, nats.ErrNoKeysFound) {
+			return nil
+		}
+
+		span.SetStatus(codes.Error, err.Error())
+		span.RecordError(err)
+
+		return err
+	}
+
+	for _, key := range keys {
+		if err := c.kv.Delete(key); err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+			span.SetStatus(codes.Error, err.Error())
+			span.RecordError(err)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Exists checks if a key exists in the cache and has not expired.
+func (c *NATSCache) Exists(ctx context.Context, id string) (bool, error) {
+	_, span := c.tracer.Start(ctx, "NATSCache.Exists")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	span.SetAttributes(attribute.String("id", id))
+
+	entry, err := c.kv.Get(id)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return false, nil
+		}
+
+		span.SetStatus(codes.Error, "failed to get key from NATS KV store")
+		span.RecordError(err)
+
+		return false, err
+	}
+
+	// entries stored by ExistsOrStore have no value and never expire before
+	// the bucket TTL
+	if len(entry.Value()) == 0 {
+		return true, nil
+	}
+
+	exp, err := time.Parse(time.RFC3339Nano, string(entry.Value()))
+	if err != nil {
+		span.SetStatus(codes.Error, "failed to parse key expiry")
+		span.RecordError(err)
+
+		return false, err
+	}
+
+	return !expired(exp), nil
+}
+
+// Store stores a key in the cache, the key expires after ttl or after the TTL
+// of the bucket, whichever comes first.
+func (c *NATSCache) Store(ctx context.Context, id string, ttl time.Duration) error {
+	_, span := c.tracer.Start(ctx, "NATSCache.Store")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	span.SetAttributes(attribute.String("id", id))
+
+	var value []byte
+	if exp := expiresAt(ttl); !exp.IsZero() {
+		value = []byte(exp.UTC().Format(time.RFC3339Nano))
+	}
+
+	if _, err := c.kv.Put(id, value); err != nil {
+		span.SetStatus(codes.Error, "failed to put key in NATS KV store")
+		span.RecordError(err)
+
+		return err
+	}
+
+	return nil
+}
+
 func (c *NATSCache) setLogger(l *zap.Logger) {
 	c.logger = l.With(zap.String("component", "nats_cache"))
 }
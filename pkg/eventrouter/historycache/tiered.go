@@ -0,0 +1,242 @@
+package historycache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+)
+
+// Consistency defines how a TieredCache uses its local and remote tiers
+type Consistency int
+
+const (
+	// ConsistencyLocalFirst answers from the local tier when it has the ID
+	// and only asks the remote tier on a local miss. This saves a round trip
+	// for every ID this replica has seen, but an ID removed from the remote
+	// tier by another replica is still reported as existing until it expires
+	// locally.
+	ConsistencyLocalFirst Consistency = iota
+	// ConsistencyAuthoritativeRemote always asks the remote tier, the local
+	// tier mirrors the answers and is only used when the remote tier fails
+	// and the cache falls back to it, see WithLocalFallback.
+	ConsistencyAuthoritativeRemote
+)
+
+// String returns the name of the consistency mode
+func (c Consistency) String() string {
+	switch c {
+	case ConsistencyLocalFirst:
+		return "local-first"
+	case ConsistencyAuthoritativeRemote:
+		return "authoritative-remote"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	_ HistoryCache = (*TieredCache)(nil)
+	_ KeyStore     = (*TieredCache)(nil)
+	_ Purger       = (*TieredCache)(nil)
+	_ configurable = (*TieredCache)(nil)
+)
+
+// TieredCache is a HistoryCache implementation with a LocalCache in front of
+// a distributed cache, e.g. NATSCache.
+//
+// The atomicity of ExistsOrStore across replicas is provided by the remote
+// tier: an ID is only reported as new when the remote tier reports it as new.
+// The local tier only ever holds IDs the remote tier has already recorded, so
+// its TTL should not exceed the TTL of the remote tier.
+type TieredCache struct {
+	local         *LocalCache
+	remote        HistoryCache
+	consistency   Consistency
+	localFallback bool
+
+	logger *zap.Logger
+	tracer trace.Tracer
+}
+
+// NewTieredCache creates a new instance of TieredCache
+func NewTieredCache(local *LocalCache, remote HistoryCache, opts ...Opt) *TieredCache {
+	c := &TieredCache{
+		local:       local,
+		remote:      remote,
+		consistency: ConsistencyLocalFirst,
+		logger:      zap.NewNop(),
+		tracer:      noop.NewTracerProvider().Tracer("tiered-cache"),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// WithConsistency is an option to set the consistency mode of a TieredCache,
+// the default is ConsistencyLocalFirst. It has no effect on other HistoryCache
+// implementations.
+func WithConsistency(consistency Consistency) Opt {
+	return func(c configurable) {
+		if tc, ok := c.(*TieredCache); ok {
+			tc.consistency = consistency
+		}
+	}
+}
+
+// WithLocalFallback is an option making a TieredCache with
+// ConsistencyAuthoritativeRemote answer from its local tier when the remote
+// tier fails, instead of returning the remote error. The answers of the local
+// tier are not atomic across replicas: while the remote tier is unavailable
+// every replica can report the same ID as new, so events can be processed
+// more than once. It has no effect on other HistoryCache implementations.
+func WithLocalFallback() Opt {
+	return func(c configurable) {
+		if tc, ok := c.(*TieredCache); ok {
+			tc.localFallback = true
+		}
+	}
+}
+
+// fallsBack reports whether the local tier answers when the remote tier fails
+func (c *TieredCache) fallsBack() bool {
+	return c.consistency == ConsistencyAuthoritativeRemote && c.localFallback
+}
+
+// ExistsOrStore is an atomic operation that checks if a correlation ID exists in the cache;
+// if it does not exist, it stores the ID and returns false, otherwise returns true.
+// The remote error is returned when the remote tier fails, unless the cache
+// falls back to the local tier, see WithLocalFallback.
+func (c *TieredCache) ExistsOrStore(ctx context.Context, id string) (bool, error) {
+	ctx, span := c.tracer.Start(ctx, "TieredCache.ExistsOrStore", trace.WithAttributes(
+		attribute.String("id", id),
+		attribute.String("consistency", c.consistency.String()),
+	))
+	defer span.End()
+
+	if c.consistency == ConsistencyLocalFirst {
+		exists, err := c.local.Exists(ctx, id)
+		if err != nil {
+			return false, err
+		}
+
+		if exists {
+			span.SetAttributes(attribute.String("tier", "local"), attribute.Bool("exists", true))
+			return true, nil
+		}
+	}
+
+	exists, err := c.remote.ExistsOrStore(ctx, id)
+	if err != nil {
+		span.RecordError(err)
+
+		if !c.fallsBack() {
+			span.SetStatus(codes.Error, "failed to query remote cache")
+			return false, err
+		}
+
+		c.logger.Warn("remote cache failed, falling back to the non-atomic local cache", zap.String("id", id), zap.Error(err))
+
+		span.SetAttributes(attribute.String("tier", "local-fallback"))
+
+		return c.local.ExistsOrStore(ctx, id)
+	}
+
+	// the remote tier has recorded the ID either way
+	if err := c.local.Store(ctx, id, 0); err != nil {
+		return false, err
+	}
+
+	span.SetAttributes(attribute.String("tier", "remote"), attribute.Bool("exists", exists))
+
+	return exists, nil
+}
+
+// Remove removes a correlation ID from both tiers.
+func (c *TieredCache) Remove(ctx context.Context, id string) error {
+	ctx, span := c.tracer.Start(ctx, "TieredCache.Remove", trace.WithAttributes(attribute.String("id", id)))
+	defer span.End()
+
+	return errors.Join(c.remote.Remove(ctx, id), c.local.Remove(ctx, id))
+}
+
+// Purge removes all the IDs from both tiers. The remote tier must implement
+// Purger.
+func (c *TieredCache) Purge(ctx context.Context) error {
+	ctx, span := c.tracer.Start(ctx, "TieredCache.Purge")
+	defer span.End()
+
+	p, ok := c.remote.(Purger)
+	if !ok {
+		return ErrNotPurger
+	}
+
+	return errors.Join(p.Purge(ctx), c.local.Purge(ctx))
+}
+
+// Exists checks if a key exists in the cache and has not expired. The remote
+// tier must implement KeyStore.
+func (c *TieredCache) Exists(ctx context.Context, id string) (bool, error) {
+	ctx, span := c.tracer.Start(ctx, "TieredCache.Exists", trace.WithAttributes(attribute.String("id", id)))
+	defer span.End()
+
+	ks, ok := c.remote.(KeyStore)
+	if !ok {
+		return false, ErrNotKeyStore
+	}
+
+	if c.consistency == ConsistencyLocalFirst {
+		exists, err := c.local.Exists(ctx, id)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+
+	exists, err := ks.Exists(ctx, id)
+	if err != nil {
+		if c.fallsBack() {
+			c.logger.Warn("remote cache failed, falling back to local cache", zap.String("id", id), zap.Error(err))
+			return c.local.Exists(ctx, id)
+		}
+
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// Store stores a key in both tiers. The remote tier must implement KeyStore.
+func (c *TieredCache) Store(ctx context.Context, id string, ttl time.Duration) error {
+	ctx, span := c.tracer.Start(ctx, "TieredCache.Store", trace.WithAttributes(attribute.String("id", id)))
+	defer span.End()
+
+	ks, ok := c.remote.(KeyStore)
+	if !ok {
+		return ErrNotKeyStore
+	}
+
+	if err := ks.Store(ctx, id, ttl); err != nil {
+		span.SetStatus(codes.Error, "failed to store key in remote cache")
+		span.RecordError(err)
+
+		return err
+	}
+
+	return c.local.Store(ctx, id, ttl)
+}
+
+func (c *TieredCache) setLogger(l *zap.Logger) {
+	c.logger = l.With(zap.String("component", "tiered_cache"))
+}
+
+func (c *TieredCache) setTracer(t trace.Tracer) {
+	c.tracer = t
+}
@@ -0,0 +1,61 @@
+package historycache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errRemoteUnavailable = errors.New("remote unavailable")
+
+// failingCache is a remote tier that is unavailable
+type failingCache struct{}
+
+func (failingCache) ExistsOrStore(context.Context, string) (bool, error) {
+	return false, errRemoteUnavailable
+}
+
+func (failingCache) Remove(context.Context, string) error {
+	return errRemoteUnavailable
+}
+
+func TestTieredCacheRemoteFailure(t *testing.T) {
+	ctx := context.Background()
+
+	for _, consistency := range []historycache.Consistency{
+		historycache.ConsistencyLocalFirst,
+		historycache.ConsistencyAuthoritativeRemote,
+	} {
+		t.Run(consistency.String(), func(t *testing.T) {
+			c := historycache.NewTieredCache(
+				historycache.NewLocalCache(),
+				failingCache{},
+				historycache.WithConsistency(consistency),
+			)
+
+			_, err := c.ExistsOrStore(ctx, "id")
+			require.ErrorIs(t, err, errRemoteUnavailable)
+		})
+	}
+
+	t.Run("local fallback", func(t *testing.T) {
+		c := historycache.NewTieredCache(
+			historycache.NewLocalCache(),
+			failingCache{},
+			historycache.WithConsistency(historycache.ConsistencyAuthoritativeRemote),
+			historycache.WithLocalFallback(),
+		)
+
+		exists, err := c.ExistsOrStore(ctx, "id")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		exists, err = c.ExistsOrStore(ctx, "id")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+}
@@ -0,0 +1,245 @@
+package eventrouter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"go.uber.org/zap"
+)
+
+// DefaultIdempotencyTTL is the default duration for which a processed event
+// is remembered by the IdempotencyProcessor
+const DefaultIdempotencyTTL = 5 * time.Minute
+
+// IdempotencyKeyFunc derives the idempotency key of an event, an empty key
+// means the event cannot be identified and is always processed
+type IdempotencyKeyFunc func(subj string, event *govevents.Event) string
+
+// IdempotencyProcessor is responsible for skipping events that were already
+// processed successfully, e.g. when NATS redelivers an event or multiple
+// subscribers receive the same event.
+type IdempotencyProcessor struct {
+	logger *zap.Logger
+
+	// store records the keys of successfully processed events
+	store   historycache.KeyStore
+	ttl     time.Duration
+	keyFunc IdempotencyKeyFunc
+}
+
+// IdempotencyProcessorOpt is a function type for configuring IdempotencyProcessor.
+type IdempotencyProcessorOpt func(*IdempotencyProcessor)
+
+// NewIdempotencyProcessor creates a new instance of IdempotencyProcessor with the provided options.
+func NewIdempotencyProcessor(opts ...IdempotencyProcessorOpt) *IdempotencyProcessor {
+	p := &IdempotencyProcessor{
+		logger:  zap.NewNop(),
+		ttl:     DefaultIdempotencyTTL,
+		keyFunc: DefaultIdempotencyKey,
+	}
+
+	// the expiry of the default store does not bound the default TTL
+	p.store = historycache.NewLocalCache(
+		historycache.WithName("idempotency"),
+		historycache.WithTTL(DefaultIdempotencyTTL),
+	)
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// IdempotencyProcessorWithStore sets the store that records processed events.
+// A distributed store, e.g. historycache.NATSCache, is required to deduplicate
+// events across replicas.
+func IdempotencyProcessorWithStore(store historycache.KeyStore) IdempotencyProcessorOpt {
+	return func(p *IdempotencyProcessor) {
+		p.store = store
+	}
+}
+
+// IdempotencyProcessorWithTTL sets how long a processed event is remembered,
+// the TTL is bounded by the expiry of the store.
+func IdempotencyProcessorWithTTL(ttl time.Duration) IdempotencyProcessorOpt {
+	return func(p *IdempotencyProcessor) {
+		p.ttl = ttl
+	}
+}
+
+// IdempotencyProcessorWithKeyFunc sets the function deriving the idempotency
+// key of an event.
+func IdempotencyProcessorWithKeyFunc(fn IdempotencyKeyFunc) IdempotencyProcessorOpt {
+	return func(p *IdempotencyProcessor) {
+		p.keyFunc = fn
+	}
+}
+
+// IdempotencyProcessorWithLogger sets the logger for IdempotencyProcessor.
+func IdempotencyProcessorWithLogger(logger *zap.Logger) IdempotencyProcessorOpt {
+	return func(p *IdempotencyProcessor) {
+		p.logger = logger
+	}
+}
+
+// DefaultIdempotencyKey derives the idempotency key from the subject, audit ID,
+// resource IDs, version and action of the event. Events without an audit ID
+// and an extension resource ID cannot be identified and get an empty key.
+//
+// The key is hashed, so it is always a valid NATS KV key.
+func DefaultIdempotencyKey(subj string, event *govevents.Event) string {
+	if event.AuditID == "" && event.ExtensionResourceID == "" {
+		return ""
+	}
+
+	h := sha256.New()
+
+	for _, part := range []string{
+		subj,
+		event.Action,
+		event.AuditID,
+		event.ExtensionResourceID,
+		event.GroupID,
+		event.UserID,
+		event.ApplicationID,
+		event.Version,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	return "idempotency." + hex.EncodeToString(h.Sum(nil))
+}
+
+// MWIdempotency returns a middleware that skips events that were already
+// processed successfully. An event is only recorded after the next handler
+// returns without error, so failed events are processed again when they are
+// redelivered.
+//
+// While an event is processed a pending marker is stored with
+// historycache.HistoryCache.ExistsOrStore, so duplicates arriving in the
+// meantime are not processed concurrently: they fail with ErrEventInFlight, so
+// they are redelivered, and skipped or processed depending on the outcome of
+// the first event. Without redeliveries, e.g. with core NATS subscriptions,
+// such a duplicate is lost if the first event fails, so delivery is at most
+// once. The marker expires with the store if the extension stops while
+// processing the event. Stores that do not implement
+// historycache.HistoryCache only skip duplicates of completed events.
+//
+// Events deferred by a middleware, e.g. held by a Coalescer, are not recorded,
+// since the handler chain returning does not mean they were processed.
+func (p *IdempotencyProcessor) MWIdempotency(next Handler) Handler {
+	return func(ctx context.Context, event *govevents.Event) error {
+		subj := GetSubjectFromContext(ctx)
+
+		key := p.keyFunc(subj, event)
+		if key == "" {
+			return next(ctx, event)
+		}
+
+		done, err := p.store.Exists(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if done {
+			p.skip(subj, event, "skipping already processed event")
+			return nil
+		}
+
+		if hc, ok := p.store.(historycache.HistoryCache); ok {
+			pending := key + ".pending"
+
+			// the claim is atomic, only one of concurrent duplicates gets it
+			inflight, err := hc.ExistsOrStore(ctx, pending)
+			if err != nil {
+				return err
+			}
+
+			if inflight {
+				p.logger.Info(
+					"event already being processed, failing the duplicate for redelivery",
+					zap.String("action", event.Action),
+					zap.String("subject", subj),
+					zap.String("resource-id", event.ExtensionResourceID),
+					zap.String("audit-id", event.AuditID),
+					zap.String("component", "idempotency-middleware"),
+				)
+
+				return ErrEventInFlight
+			}
+
+			defer p.release(ctx, hc, pending, subj)
+
+			// the event may have completed between the lookup and the claim
+			done, err := p.store.Exists(ctx, key)
+			if err != nil {
+				return err
+			}
+
+			if done {
+				p.skip(subj, event, "skipping already processed event")
+				return nil
+			}
+		}
+
+		if err := next(ctx, event); err != nil {
+			return err
+		}
+
+		if isDeferred(ctx) {
+			p.logger.Debug(
+				"not recording deferred event",
+				zap.String("subject", subj),
+				zap.String("resource-id", event.ExtensionResourceID),
+				zap.String("component", "idempotency-middleware"),
+			)
+
+			return nil
+		}
+
+		// the event was processed, failing to record it only means that a
+		// duplicate would be processed again
+		if err := p.store.Store(ctx, key, p.ttl); err != nil {
+			p.logger.Warn(
+				"failed recording processed event",
+				zap.Error(err),
+				zap.String("subject", subj),
+				zap.String("component", "idempotency-middleware"),
+			)
+		}
+
+		return nil
+	}
+}
+
+func (p *IdempotencyProcessor) skip(subj string, event *govevents.Event, msg string) {
+	p.logger.Info(
+		msg,
+		zap.String("action", event.Action),
+		zap.String("subject", subj),
+		zap.String("resource-id", event.ExtensionResourceID),
+		zap.String("audit-id", event.AuditID),
+		zap.String("component", "idempotency-middleware"),
+	)
+
+	eventsDeduplicatedTotal.WithLabelValues(subj, event.Action).Inc()
+}
+
+// release removes the pending marker of an event, a marker that cannot be
+// removed expires with the store
+func (p *IdempotencyProcessor) release(ctx context.Context, hc historycache.HistoryCache, pending, subj string) {
+	if err := hc.Remove(context.WithoutCancel(ctx), pending); err != nil {
+		p.logger.Warn(
+			"failed removing pending event marker",
+			zap.Error(err),
+			zap.String("subject", subj),
+			zap.String("component", "idempotency-middleware"),
+		)
+	}
+}
@@ -0,0 +1,190 @@
+package eventrouter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errIdempotency = errors.New("handler failed")
+
+func idempotencyEvent() *govevents.Event {
+	return &govevents.Event{
+		Action:              govevents.GovernorEventUpdate,
+		AuditID:             "audit-id",
+		ExtensionResourceID: "resource-id",
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	var (
+		calls atomic.Int32
+		fail  atomic.Bool
+	)
+
+	r := NewRouter(WithIdempotencyProcessor(NewIdempotencyProcessor()))
+	r.Update("idempotency", func(context.Context, *govevents.Event) error {
+		calls.Add(1)
+
+		if fail.Load() {
+			return errIdempotency
+		}
+
+		return nil
+	})
+
+	// failed events are processed again
+	fail.Store(true)
+	require.ErrorIs(t, r.Process(context.Background(), "idempotency", idempotencyEvent()), errIdempotency)
+
+	fail.Store(false)
+	require.NoError(t, r.Process(context.Background(), "idempotency", idempotencyEvent()))
+
+	// processed events are skipped
+	require.NoError(t, r.Process(context.Background(), "idempotency", idempotencyEvent()))
+	assert.Equal(t, int32(2), calls.Load())
+
+	// events without a key are always processed
+	event := idempotencyEvent()
+	event.AuditID, event.ExtensionResourceID = "", ""
+
+	require.NoError(t, r.Process(context.Background(), "idempotency", event))
+	require.NoError(t, r.Process(context.Background(), "idempotency", event))
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestIdempotencyInFlightDuplicate(t *testing.T) {
+	var calls atomic.Int32
+
+	started, release := make(chan struct{}), make(chan struct{})
+
+	r := NewRouter(WithIdempotencyProcessor(NewIdempotencyProcessor()))
+	r.Update("idempotency", func(context.Context, *govevents.Event) error {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+
+		return nil
+	})
+
+	done := make(chan error)
+
+	go func() {
+		done <- r.Process(context.Background(), "idempotency", idempotencyEvent())
+	}()
+
+	<-started
+
+	// the duplicate arrives while the first event is being processed, it is
+	// failed so it is redelivered
+	require.ErrorIs(t, r.Process(context.Background(), "idempotency", idempotencyEvent()), ErrEventInFlight)
+
+	close(release)
+	require.NoError(t, <-done)
+
+	require.NoError(t, r.Process(context.Background(), "idempotency", idempotencyEvent()))
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestIdempotencyInFlightDuplicateFirstFails(t *testing.T) {
+	var calls atomic.Int32
+
+	started, release := make(chan struct{}), make(chan struct{})
+
+	r := NewRouter(WithIdempotencyProcessor(NewIdempotencyProcessor()))
+	r.Update("idempotency", func(context.Context, *govevents.Event) error {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+
+			return errIdempotency
+		}
+
+		return nil
+	})
+
+	done := make(chan error)
+
+	go func() {
+		done <- r.Process(context.Background(), "idempotency", idempotencyEvent())
+	}()
+
+	<-started
+
+	require.ErrorIs(t, r.Process(context.Background(), "idempotency", idempotencyEvent()), ErrEventInFlight)
+
+	close(release)
+	require.ErrorIs(t, <-done, errIdempotency)
+
+	// the redelivered duplicate is processed since the first event failed
+	require.NoError(t, r.Process(context.Background(), "idempotency", idempotencyEvent()))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestIdempotencyPendingMarkerReleased(t *testing.T) {
+	store := historycache.NewLocalCache()
+
+	r := NewRouter(WithIdempotencyProcessor(NewIdempotencyProcessor(IdempotencyProcessorWithStore(store))))
+	r.Update("idempotency", func(context.Context, *govevents.Event) error {
+		return errIdempotency
+	})
+
+	require.ErrorIs(t, r.Process(context.Background(), "idempotency", idempotencyEvent()), errIdempotency)
+
+	pending, err := store.Exists(context.Background(), DefaultIdempotencyKey("idempotency", idempotencyEvent())+".pending")
+	require.NoError(t, err)
+	assert.False(t, pending)
+}
+
+// keyStoreOnly hides the HistoryCache methods of a store
+type keyStoreOnly struct {
+	historycache.KeyStore
+}
+
+func TestIdempotencyKeyStoreOnly(t *testing.T) {
+	var calls atomic.Int32
+
+	p := NewIdempotencyProcessor(IdempotencyProcessorWithStore(keyStoreOnly{historycache.NewLocalCache()}))
+
+	r := NewRouter(WithIdempotencyProcessor(p))
+	r.Update("idempotency", func(context.Context, *govevents.Event) error {
+		calls.Add(1)
+		return nil
+	})
+
+	require.NoError(t, r.Process(context.Background(), "idempotency", idempotencyEvent()))
+	require.NoError(t, r.Process(context.Background(), "idempotency", idempotencyEvent()))
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestIdempotencyDeferredNotRecorded(t *testing.T) {
+	var calls atomic.Int32
+
+	store := historycache.NewLocalCache()
+	c := NewCoalescer(CoalescerWithWindow(time.Hour))
+
+	r := NewRouter(WithIdempotencyProcessor(NewIdempotencyProcessor(IdempotencyProcessorWithStore(store))))
+	r.Update("idempotency", func(context.Context, *govevents.Event) error {
+		calls.Add(1)
+		return nil
+	}, c.MWCoalesce)
+
+	// the coalescer holds the event, it was not processed yet
+	require.NoError(t, r.Process(context.Background(), "idempotency", idempotencyEvent()))
+	assert.Zero(t, calls.Load())
+
+	done, err := store.Exists(context.Background(), DefaultIdempotencyKey("idempotency", idempotencyEvent()))
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	c.Flush()
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,170 @@
+package eventrouter
+
+import (
+	"cmp"
+	"context"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+)
+
+// Introspector is implemented by event routers that can describe their routes
+// and explain how an event would be processed
+type Introspector interface {
+	Routes() []RouteInfo
+	Explain(ctx context.Context, subj string, event *govevents.Event) (*Explanation, error)
+}
+
+// route is a registered handler, wrapped with its route middlewares
+type route struct {
+	handler Handler
+	// compiled is the handler wrapped with the global middlewares
+	compiled    Handler
+	name        string
+	middlewares []string
+}
+
+// RouteInfo describes a route registered with the router
+type RouteInfo struct {
+	Subject string `json:"subject"`
+	Action  string `json:"action"`
+	// Handler is the name of the handler function
+	Handler string `json:"handler"`
+	// GlobalMiddlewares are the names of the global middlewares in execution
+	// order, they are executed before the route middlewares
+	GlobalMiddlewares []string `json:"global_middlewares"`
+	// RouteMiddlewares are the names of the route middlewares in execution
+	// order
+	RouteMiddlewares []string `json:"route_middlewares"`
+}
+
+// Explanation describes how the router would process an event
+type Explanation struct {
+	Subject string `json:"subject"`
+	Action  string `json:"action"`
+	// Route is the route that would handle the event, nil if there is none
+	Route *RouteInfo `json:"route,omitempty"`
+	// CorrelationID is the correlation ID of the event
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Skipped reports whether the correlation ID processor would skip the event
+	Skipped bool `json:"skipped"`
+	// SkipRule is the name of the skip rule deciding whether the event is
+	// skipped, if any
+	SkipRule string `json:"skip_rule,omitempty"`
+	// Reason describes the outcome in a human readable form
+	Reason string `json:"reason"`
+}
+
+// Routes returns every route registered with the router, sorted by subject
+// and action
+func (r *Router) Routes() []RouteInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := []RouteInfo{}
+
+	for subj, actions := range r.routes {
+		for action, rt := range actions {
+			routes = append(routes, r.routeInfo(subj, action, rt))
+		}
+	}
+
+	slices.SortFunc(routes, func(a, b RouteInfo) int {
+		return cmp.Or(cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.Action, b.Action))
+	})
+
+	return routes
+}
+
+// Explain tells how the router would process the event on the subject,
+// without running any handler or middleware and without recording the
+// correlation ID of the event.
+func (r *Router) Explain(ctx context.Context, subj string, event *govevents.Event) (*Explanation, error) {
+	exp := &Explanation{Subject: subj, Action: event.Action}
+
+	exp.CorrelationID = eventCorrelationID(event)
+
+	r.mu.RLock()
+
+	actions, ok := r.routes[subj]
+	if !ok {
+		r.mu.RUnlock()
+
+		exp.Reason = ErrHandlerNotFound.Error()
+
+		return exp, nil
+	}
+
+	rt, ok := actions[event.Action]
+	if ok {
+		info := r.routeInfo(subj, event.Action, rt)
+		exp.Route = &info
+	}
+
+	r.mu.RUnlock()
+
+	if !ok {
+		exp.Reason = "no handler registered for action"
+		return exp, nil
+	}
+
+	if r.correlationIDProcessor != nil {
+		skip, rule, err := r.correlationIDProcessor.WouldSkipEvent(ctx, subj, event)
+		if err != nil {
+			return nil, err
+		}
+
+		exp.SkipRule = rule
+
+		if skip {
+			exp.Skipped = true
+			exp.Reason = "skipped by rule " + rule
+
+			return exp, nil
+		}
+	}
+
+	exp.Reason = "handled by " + rt.name
+
+	return exp, nil
+}
+
+// routeInfo describes a route, it must be called with the lock held
+func (r *Router) routeInfo(subj, action string, rt *route) RouteInfo {
+	mws := slices.Clone(rt.middlewares)
+	if r.defaultTimeout > 0 {
+		mws = append(mws, "default-timeout")
+	}
+
+	return RouteInfo{
+		Subject:           subj,
+		Action:            action,
+		Handler:           rt.name,
+		GlobalMiddlewares: slices.Clone(r.middlewares),
+		RouteMiddlewares:  mws,
+	}
+}
+
+// funcName returns a short name of a function, e.g. "eventrouter.Timeout.func1"
+// or "processor.(*Processor).ProcessEvent" for method values
+func funcName(fn any) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+
+	f := runtime.FuncForPC(v.Pointer())
+	if f == nil {
+		return "unknown"
+	}
+
+	name := strings.TrimSuffix(f.Name(), "-fm")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
@@ -0,0 +1,117 @@
+package eventrouter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type introspectProcessor struct{}
+
+func (introspectProcessor) ProcessEvent(context.Context, *govevents.Event) error { return nil }
+
+func TestRoutes(t *testing.T) {
+	r := NewRouter(
+		WithDefaultTimeout(time.Minute),
+		WithCorrelationIDProcessor(NewCorrelationIDProcessor()),
+		WithMiddleware(noopMiddleware),
+	)
+
+	p := &introspectProcessor{}
+
+	r.Update("users", noopHandler)
+	r.Create("groups", p.ProcessEvent, noopMiddleware, Timeout(time.Second))
+	r.Delete("groups", noopHandler)
+
+	routes := r.Routes()
+	require.Len(t, routes, 3)
+
+	// sorted by subject and action
+	assert.Equal(t, "groups", routes[0].Subject)
+	assert.Equal(t, govevents.GovernorEventCreate, routes[0].Action)
+	assert.Equal(t, "groups", routes[1].Subject)
+	assert.Equal(t, govevents.GovernorEventDelete, routes[1].Action)
+	assert.Equal(t, "users", routes[2].Subject)
+
+	assert.Equal(t, "eventrouter.introspectProcessor.ProcessEvent", routes[0].Handler)
+	assert.Equal(t, "eventrouter.noopHandler", routes[1].Handler)
+
+	// the last applied global middleware is executed first, recovery is the
+	// innermost one
+	assert.Equal(t, []string{"eventrouter.noopMiddleware", "correlation-id", "recovery"}, routes[0].GlobalMiddlewares)
+
+	// route middlewares in execution order, the last one is executed first and
+	// the default timeout wraps the handler
+	assert.Equal(t, []string{"eventrouter.Timeout.func1", "eventrouter.noopMiddleware", "default-timeout"}, routes[0].RouteMiddlewares)
+	assert.Equal(t, []string{"default-timeout"}, routes[1].RouteMiddlewares)
+
+	// middlewares added later are reflected
+	r.Use(noopMiddleware)
+	assert.Len(t, r.Routes()[0].GlobalMiddlewares, 4) //nolint: mnd
+}
+
+func TestExplain(t *testing.T) {
+	r := NewRouter(WithCorrelationIDProcessor(NewCorrelationIDProcessor(CorrelationIDProcessorWithSkipStrategySkipAll())))
+	r.Update("groups", noopHandler)
+
+	event := func(action string) *govevents.Event {
+		return &govevents.Event{
+			Action: action,
+			Headers: map[string][]string{
+				govevents.GovernorEventCorrelationIDHeader: {"cid"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		subj    string
+		action  string
+		route   bool
+		skipped bool
+		rule    string
+		reason  string
+	}{
+		{"unknown subject", "users", govevents.GovernorEventUpdate, false, false, "", ErrHandlerNotFound.Error()},
+		{"unknown action", "groups", govevents.GovernorEventCreate, false, false, "", "no handler registered for action"},
+		{"handled", "groups", govevents.GovernorEventUpdate, true, false, "", "handled by eventrouter.noopHandler"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp, err := r.Explain(context.Background(), tt.subj, event(tt.action))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.subj, exp.Subject)
+			assert.Equal(t, tt.action, exp.Action)
+			assert.Equal(t, "cid", exp.CorrelationID)
+			assert.Equal(t, tt.route, exp.Route != nil)
+			assert.Equal(t, tt.skipped, exp.Skipped)
+			assert.Equal(t, tt.rule, exp.SkipRule)
+			assert.Equal(t, tt.reason, exp.Reason)
+		})
+	}
+
+	// explaining does not record the correlation ID, the event is processed
+	var calls int
+
+	r.Update("groups", func(context.Context, *govevents.Event) error {
+		calls++
+		return nil
+	})
+
+	require.NoError(t, r.Process(context.Background(), "groups", event(govevents.GovernorEventUpdate)))
+	assert.Equal(t, 1, calls)
+
+	// once recorded, the event would be skipped
+	exp, err := r.Explain(context.Background(), "groups", event(govevents.GovernorEventUpdate))
+	require.NoError(t, err)
+
+	assert.True(t, exp.Skipped)
+	assert.Equal(t, "skip-all", exp.SkipRule)
+	assert.Equal(t, "skipped by rule skip-all", exp.Reason)
+}
@@ -0,0 +1,130 @@
+package eventrouter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/hops"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// DefaultMaxHops is the default maximum number of hops of an event
+const DefaultMaxHops = 5
+
+// LoopAlertFunc is called when the loop detector drops an event, err wraps
+// ErrLoopDetected
+type LoopAlertFunc func(ctx context.Context, event *govevents.Event, err error)
+
+// LoopDetector drops events that went through too many extensions, or that
+// came back to this extension through another extension, to break loops
+// between extensions updating each other's resources. The events caused by the
+// extension's own writes are left to the correlation ID processor.
+//
+// The hops are read from the X-Governor-Extension-Hops and
+// X-Governor-Extension-Path event headers, the detector does nothing until the
+// Governor API forwards them from the requests to the resulting events.
+type LoopDetector struct {
+	extensionID string
+	maxHops     int
+	alert       LoopAlertFunc
+	logger      *zap.Logger
+}
+
+// LoopDetectorOpt is a function type for configuring LoopDetector
+type LoopDetectorOpt func(*LoopDetector)
+
+// NewLoopDetector creates a new LoopDetector for the extension. It only
+// detects loops once the Governor API forwards the hop headers sent by the
+// roundtripper to the resulting events.
+func NewLoopDetector(extensionID string, opts ...LoopDetectorOpt) *LoopDetector {
+	ld := &LoopDetector{
+		extensionID: extensionID,
+		maxHops:     DefaultMaxHops,
+		logger:      zap.NewNop(),
+	}
+
+	for _, opt := range opts {
+		opt(ld)
+	}
+
+	ld.logger = ld.logger.With(zap.String("component", "loop-detection-middleware"))
+
+	return ld
+}
+
+// LoopDetectorWithMaxHops sets the maximum number of hops of an event, events
+// with more hops are dropped. A zero or negative value disables the limit.
+func LoopDetectorWithMaxHops(n int) LoopDetectorOpt {
+	return func(ld *LoopDetector) {
+		ld.maxHops = n
+	}
+}
+
+// LoopDetectorWithAlertFunc sets a function called when an event is dropped,
+// e.g. to page someone, in addition to the error log and metric
+func LoopDetectorWithAlertFunc(fn LoopAlertFunc) LoopDetectorOpt {
+	return func(ld *LoopDetector) {
+		ld.alert = fn
+	}
+}
+
+// LoopDetectorWithLogger sets the logger for LoopDetector
+func LoopDetectorWithLogger(logger *zap.Logger) LoopDetectorOpt {
+	return func(ld *LoopDetector) {
+		ld.logger = logger
+	}
+}
+
+// MWDetectLoops is a middleware that drops the events exceeding the hop limit
+// or looping back to this extension through another one. Other events are processed with the next hops
+// saved to the context, so they are propagated by the roundtripper.
+func (ld *LoopDetector) MWDetectLoops(next Handler) Handler {
+	return func(ctx context.Context, event *govevents.Event) error {
+		h := hops.FromHeader(nats.Header(event.Headers))
+
+		var reason string
+
+		switch {
+		case ld.maxHops > 0 && h.Count > ld.maxHops:
+			reason = fmt.Sprintf("hop limit of %d exceeded", ld.maxHops)
+		case ld.extensionID != "" && h.LoopsThrough(ld.extensionID):
+			reason = "event looped back to extension " + ld.extensionID
+		}
+
+		if reason == "" {
+			return next(hops.Inject(ctx, h.Next(ld.extensionID)), event)
+		}
+
+		subj := GetSubjectFromContext(ctx)
+		err := fmt.Errorf("%w: %s, path %s", ErrLoopDetected, reason, strings.Join(h.Path, ","))
+
+		ld.logger.Error(
+			"dropping event",
+			zap.String("subject", subj),
+			zap.String("action", event.Action),
+			zap.String("resource-id", event.ExtensionResourceID),
+			zap.Int("hops", h.Count),
+			zap.Strings("path", h.Path),
+			zap.Error(err),
+		)
+
+		trace.SpanFromContext(ctx).AddEvent("loop detected", trace.WithAttributes(
+			attribute.Int("governor.extension.hops", h.Count),
+			attribute.StringSlice("governor.extension.path", h.Path),
+			attribute.String("reason", reason),
+		))
+
+		eventsLoopDetectedTotal.WithLabelValues(subj, event.Action).Inc()
+
+		if ld.alert != nil {
+			ld.alert(ctx, event, err)
+		}
+
+		return nil
+	}
+}
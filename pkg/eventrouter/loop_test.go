@@ -0,0 +1,106 @@
+package eventrouter
+
+import (
+	"context"
+	"testing"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/hops"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopDetector(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   string
+		path    string
+		dropped bool
+		next    hops.Hops
+	}{
+		{
+			name: "no hops",
+			next: hops.Hops{Count: 1, Path: []string{"ext-a"}},
+		},
+		{
+			name:  "other extensions",
+			count: "2",
+			path:  "ext-b,ext-c",
+			next:  hops.Hops{Count: 3, Path: []string{"ext-b", "ext-c", "ext-a"}}, //nolint: mnd
+		},
+		{
+			name:  "own write",
+			count: "1",
+			path:  "ext-a",
+			next:  hops.Hops{Count: 2, Path: []string{"ext-a", "ext-a"}}, //nolint: mnd
+		},
+		{
+			name:  "hop limit reached",
+			count: "3",
+			path:  "ext-b,ext-c,ext-d",
+			next:  hops.Hops{Count: 4, Path: []string{"ext-b", "ext-c", "ext-d", "ext-a"}}, //nolint: mnd
+		},
+		{
+			name:    "hop limit exceeded",
+			count:   "4",
+			path:    "ext-b,ext-c,ext-d,ext-e",
+			dropped: true,
+		},
+		{
+			name:    "looped back through another extension",
+			count:   "2",
+			path:    "ext-a,ext-b",
+			dropped: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				called   bool
+				next     hops.Hops
+				alertErr error
+			)
+
+			ld := NewLoopDetector("ext-a",
+				LoopDetectorWithMaxHops(3), //nolint: mnd
+				LoopDetectorWithAlertFunc(func(_ context.Context, _ *govevents.Event, err error) {
+					alertErr = err
+				}),
+			)
+
+			r := NewRouter(WithLoopDetector(ld))
+			r.Update("groups", func(ctx context.Context, _ *govevents.Event) error {
+				called = true
+				next = hops.Extract(ctx)
+
+				return nil
+			})
+
+			headers := map[string][]string{}
+			if tt.count != "" {
+				headers[hops.CountHeader] = []string{tt.count}
+			}
+
+			if tt.path != "" {
+				headers[hops.PathHeader] = []string{tt.path}
+			}
+
+			err := r.Process(context.Background(), "groups", &govevents.Event{
+				Action:  govevents.GovernorEventUpdate,
+				Headers: headers,
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, !tt.dropped, called)
+
+			if tt.dropped {
+				assert.ErrorIs(t, alertErr, ErrLoopDetected)
+				return
+			}
+
+			assert.NoError(t, alertErr)
+			assert.Equal(t, tt.next, next)
+		})
+	}
+}
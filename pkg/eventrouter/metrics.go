@@ -0,0 +1,156 @@
+package eventrouter
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "governor_extension"
+	metricsSubsystem = "eventrouter"
+)
+
+var (
+	handlerPanicsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "handler_panics_total",
+			Help:      "Total number of panics recovered from event handlers.",
+		},
+		[]string{"subject", "action"},
+	)
+
+	handlerTimeoutsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "handler_timeouts_total",
+			Help:      "Total number of event handlers that did not complete within their timeout.",
+		},
+		[]string{"subject", "action"},
+	)
+
+	handlersAbandoned = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "handlers_abandoned",
+			Help:      "Number of event handlers still running after their timeout expired.",
+		},
+	)
+
+	abandonedHandlerResultsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "abandoned_handler_results_total",
+			Help:      "Total number of event handlers that returned after their timeout expired, by result.",
+		},
+		[]string{"subject", "action", "result"},
+	)
+
+	eventsFilteredTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_filtered_total",
+			Help:      "Total number of events dropped by event filters.",
+		},
+		[]string{"filter", "subject", "action"},
+	)
+
+	eventsDeduplicatedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_deduplicated_total",
+			Help:      "Total number of events skipped because they were already processed.",
+		},
+		[]string{"subject", "action"},
+	)
+
+	eventsCoalescedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_coalesced_total",
+			Help:      "Total number of events collapsed into a later event by the coalescer.",
+		},
+		[]string{"subject", "action"},
+	)
+
+	eventsLoopDetectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_loop_detected_total",
+			Help:      "Total number of events dropped by the loop detector.",
+		},
+		[]string{"subject", "action"},
+	)
+
+	eventsSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_skipped_total",
+			Help:      "Total number of events skipped by the correlation ID processor, by skip rule.",
+		},
+		[]string{"subject", "action", "rule"},
+	)
+)
+
+// metrics are the collectors of the package
+var metrics = []prometheus.Collector{
+	handlerPanicsTotal,
+	handlerTimeoutsTotal,
+	handlersAbandoned,
+	abandonedHandlerResultsTotal,
+	eventsFilteredTotal,
+	eventsDeduplicatedTotal,
+	eventsCoalescedTotal,
+	eventsLoopDetectedTotal,
+	eventsSkippedTotal,
+}
+
+var (
+	metricsMu sync.Mutex
+	// registered holds the registerers the metrics are registered with
+	registered = make(map[prometheus.Registerer]struct{})
+)
+
+// registerMetrics registers the metrics with reg the first time a Router is
+// created with it. Metrics already registered by another copy of the package
+// are skipped, other registration errors are returned instead of panicking.
+func registerMetrics(reg prometheus.Registerer) error {
+	if reg == nil {
+		return nil
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	if _, ok := registered[reg]; ok {
+		return nil
+	}
+
+	registered[reg] = struct{}{}
+
+	var errs []error
+
+	for _, c := range metrics {
+		if err := reg.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if errors.As(err, &are) {
+				continue
+			}
+
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,78 @@
+package eventrouter
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const abandonedMetric = "governor_extension_eventrouter_handlers_abandoned"
+
+// gathered returns the names of the metrics gathered from reg
+func gathered(t *testing.T, reg *prometheus.Registry) []string {
+	t.Helper()
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(families))
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+
+	return names
+}
+
+func TestWithMetricsRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	// the metrics are registered once per registerer
+	assert.NotPanics(t, func() {
+		NewRouter(WithMetricsRegisterer(reg))
+		NewRouter(WithMetricsRegisterer(reg))
+	})
+
+	assert.Contains(t, gathered(t, reg), abandonedMetric)
+
+	// the metrics can be registered with more than one registerer
+	other := prometheus.NewRegistry()
+	NewRouter(WithMetricsRegisterer(other))
+	assert.Contains(t, gathered(t, other), abandonedMetric)
+
+	// a nil registerer disables the registration
+	assert.NotPanics(t, func() {
+		NewRouter(WithMetricsRegisterer(nil))
+	})
+}
+
+func TestWithMetricsRegistererConflict(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	// another copy of the metric is skipped
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: abandonedMetric,
+		Help: "Number of event handlers still running after their timeout expired.",
+	}))
+
+	// a conflicting metric is logged instead of panicking
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "governor_extension_eventrouter_handler_panics_total",
+		Help: "Conflicting metric.",
+	}))
+
+	core, logs := observer.New(zap.WarnLevel)
+
+	assert.NotPanics(t, func() {
+		NewRouter(WithMetricsRegisterer(reg), WithLogger(zap.New(core)))
+	})
+
+	assert.Equal(t, 1, logs.FilterMessage("failed to register metrics").Len())
+
+	// the other metrics are still registered
+	eventsSkippedTotal.WithLabelValues("subject", "action", "rule")
+	assert.Contains(t, gathered(t, reg), "governor_extension_eventrouter_events_skipped_total")
+}
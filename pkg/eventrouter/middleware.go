@@ -16,7 +16,7 @@ type Middleware func(Handler) Handler
 
 func (r *Router) mwInjectTraceContext(handler Handler) Handler {
 	return func(ctx context.Context, event *govevents.Event) error {
-		r.logger.Debug(
+		GetLoggerFromContext(ctx).Debug(
 			"extracting trace context from event",
 			zap.String("component", "trace-context-middleware"),
 		)
@@ -26,17 +26,35 @@ func (r *Router) mwInjectTraceContext(handler Handler) Handler {
 			ctx = otel.GetTextMapPropagator().Extract(ctx, parentctx)
 		}
 
-		tracectx, span := r.tracer.Start(
-			ctx, "process-event",
+		startopts := []trace.SpanStartOption{
 			trace.WithAttributes(
 				attribute.String("event.erd-id", event.ExtensionResourceDefinitionID),
 				attribute.String("event.extension-id", event.ExtensionID),
 				attribute.String("event.resource-id", event.ExtensionResourceID),
 				attribute.String("event.resource-version", event.Version),
 			),
-		)
+		}
+
+		// link the processing span to the span of the write that caused the
+		// event, so a loop through the Governor API is navigable
+		if cid := eventCorrelationID(event); cid != "" {
+			startopts = append(startopts, trace.WithAttributes(attribute.String(CorrelationIDKey, cid)))
+
+			if r.correlationIDProcessor != nil {
+				if link, ok := r.correlationIDProcessor.writeSpanLink(cid); ok {
+					startopts = append(startopts, trace.WithLinks(link))
+				}
+			}
+		}
+
+		tracectx, span := r.tracer.Start(ctx, "process-event", startopts...)
 		defer span.End()
 
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			logger := GetLoggerFromContext(tracectx).With(zap.String("trace-id", sc.TraceID().String()))
+			tracectx = SaveLoggerToContext(tracectx, logger)
+		}
+
 		return handler(tracectx, event)
 	}
 }
@@ -0,0 +1,115 @@
+package eventrouter
+
+import (
+	"slices"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/nats-io/nats.go"
+)
+
+// Predicate reports whether an event matches a condition
+type Predicate func(*govevents.Event) bool
+
+// eventFields maps the field names used in filter expressions to the event
+// fields
+var eventFields = map[string]func(*govevents.Event) string{
+	"action":         func(e *govevents.Event) string { return e.Action },
+	"version":        func(e *govevents.Event) string { return e.Version },
+	"audit_id":       func(e *govevents.Event) string { return e.AuditID },
+	"actor_id":       func(e *govevents.Event) string { return e.ActorID },
+	"group_id":       func(e *govevents.Event) string { return e.GroupID },
+	"user_id":        func(e *govevents.Event) string { return e.UserID },
+	"application_id": func(e *govevents.Event) string { return e.ApplicationID },
+	"extension_id":   func(e *govevents.Event) string { return e.ExtensionID },
+	"erd_id":         func(e *govevents.Event) string { return e.ExtensionResourceDefinitionID },
+	"resource_id":    func(e *govevents.Event) string { return e.ExtensionResourceID },
+}
+
+// And returns a predicate that matches when all predicates match
+func And(preds ...Predicate) Predicate {
+	return func(e *govevents.Event) bool {
+		for _, p := range preds {
+			if !p(e) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Or returns a predicate that matches when any of the predicates match
+func Or(preds ...Predicate) Predicate {
+	return func(e *govevents.Event) bool {
+		for _, p := range preds {
+			if p(e) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Not returns a predicate that matches when the predicate does not match
+func Not(pred Predicate) Predicate {
+	return func(e *govevents.Event) bool {
+		return !pred(e)
+	}
+}
+
+// ActionIn returns a predicate that matches events with one of the actions
+func ActionIn(actions ...string) Predicate {
+	return fieldIn(eventFields["action"], actions)
+}
+
+// ActorIDIn returns a predicate that matches events caused by one of the actors
+func ActorIDIn(ids ...string) Predicate {
+	return fieldIn(eventFields["actor_id"], ids)
+}
+
+// GroupIDIn returns a predicate that matches events for one of the groups
+func GroupIDIn(ids ...string) Predicate {
+	return fieldIn(eventFields["group_id"], ids)
+}
+
+// UserIDIn returns a predicate that matches events for one of the users
+func UserIDIn(ids ...string) Predicate {
+	return fieldIn(eventFields["user_id"], ids)
+}
+
+// ApplicationIDIn returns a predicate that matches events for one of the
+// applications
+func ApplicationIDIn(ids ...string) Predicate {
+	return fieldIn(eventFields["application_id"], ids)
+}
+
+// ExtensionIDIn returns a predicate that matches events for one of the
+// extensions
+func ExtensionIDIn(ids ...string) Predicate {
+	return fieldIn(eventFields["extension_id"], ids)
+}
+
+// ERDIDIn returns a predicate that matches events for one of the extension
+// resource definitions
+func ERDIDIn(ids ...string) Predicate {
+	return fieldIn(eventFields["erd_id"], ids)
+}
+
+// HeaderIn returns a predicate that matches events where the first value of
+// the header is one of the values
+func HeaderIn(key string, values ...string) Predicate {
+	return fieldIn(headerField(key), values)
+}
+
+func headerField(key string) func(*govevents.Event) string {
+	return func(e *govevents.Event) string {
+		return nats.Header(e.Headers).Get(key)
+	}
+}
+
+func fieldIn(field func(*govevents.Event) string, values []string) Predicate {
+	return func(e *govevents.Event) bool {
+		return slices.Contains(values, field(e))
+	}
+}
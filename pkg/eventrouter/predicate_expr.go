@@ -0,0 +1,337 @@
+package eventrouter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+)
+
+// ParsePredicate parses a filter expression into a predicate. Expressions
+// compare event fields with quoted strings and can be combined with &&, ||, !
+// and parentheses, e.g.:
+//
+//	action == "UPDATE" && group_id in ("a", "b") && !(actor_id == "c")
+//	header["X-Governor-Source"] != "sync"
+//
+// The supported comparisons are ==, !=, in and not in. The supported fields are
+// action, version, audit_id, actor_id, group_id, user_id, application_id,
+// extension_id, erd_id, resource_id and header["name"].
+func ParsePredicate(expr string) (Predicate, error) {
+	tokens, err := tokenizePredicate(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &predicateParser{tokens: tokens}
+
+	pred, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.value)
+	}
+
+	return pred, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenOp
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func tokenizePredicate(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			// find the closing quote, skipping escaped characters
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+
+				end++
+			}
+
+			if end >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrInvalidPredicate, i)
+			}
+
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid string at %d: %s", ErrInvalidPredicate, i, err)
+			}
+
+			tokens = append(tokens, token{kind: tokenString, value: s, pos: i})
+			i = end + 1
+		case c == '_' || unicode.IsLetter(c):
+			end := i
+			for end < len(expr) && (expr[end] == '_' || unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+
+			tokens = append(tokens, token{kind: tokenIdent, value: expr[i:end], pos: i})
+			i = end
+		default:
+			op := ""
+
+			for _, candidate := range []string{"&&", "||", "==", "!=", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidPredicate, c, i)
+			}
+
+			tokens = append(tokens, token{kind: tokenOp, value: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, value: "EOF", pos: len(expr)}), nil
+}
+
+type predicateParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *predicateParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *predicateParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *predicateParser) accept(kind tokenKind, value string) bool {
+	if tok := p.peek(); tok.kind == kind && tok.value == value {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *predicateParser) expect(kind tokenKind, value string) error {
+	if tok := p.peek(); !p.accept(kind, value) {
+		return p.errorf(tok, "expected %q, got %q", value, tok.value)
+	}
+
+	return nil
+}
+
+func (p *predicateParser) errorf(tok token, format string, args ...any) error {
+	return fmt.Errorf("%w: %s at %d", ErrInvalidPredicate, fmt.Sprintf(format, args...), tok.pos)
+}
+
+// parseOr parses: and ("||" and)*
+func (p *predicateParser) parseOr() (Predicate, error) {
+	pred, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	preds := []Predicate{pred}
+
+	for p.accept(tokenOp, "||") {
+		pred, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		preds = append(preds, pred)
+	}
+
+	if len(preds) == 1 {
+		return preds[0], nil
+	}
+
+	return Or(preds...), nil
+}
+
+// parseAnd parses: unary ("&&" unary)*
+func (p *predicateParser) parseAnd() (Predicate, error) {
+	pred, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	preds := []Predicate{pred}
+
+	for p.accept(tokenOp, "&&") {
+		pred, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		preds = append(preds, pred)
+	}
+
+	if len(preds) == 1 {
+		return preds[0], nil
+	}
+
+	return And(preds...), nil
+}
+
+// parseUnary parses: "!" unary | "(" or ")" | comparison
+func (p *predicateParser) parseUnary() (Predicate, error) {
+	if p.accept(tokenOp, "!") {
+		pred, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return Not(pred), nil
+	}
+
+	if p.accept(tokenOp, "(") {
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(tokenOp, ")"); err != nil {
+			return nil, err
+		}
+
+		return pred, nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses: field ("==" | "!=") string | field ["not"] "in" list
+func (p *predicateParser) parseComparison() (Predicate, error) {
+	field, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.next()
+
+	switch {
+	case tok.kind == tokenOp && (tok.value == "==" || tok.value == "!="):
+		val := p.next()
+		if val.kind != tokenString {
+			return nil, p.errorf(val, "expected string, got %q", val.value)
+		}
+
+		pred := fieldIn(field, []string{val.value})
+		if tok.value == "!=" {
+			pred = Not(pred)
+		}
+
+		return pred, nil
+	case tok.kind == tokenIdent && tok.value == "in":
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+
+		return fieldIn(field, values), nil
+	case tok.kind == tokenIdent && tok.value == "not":
+		if err := p.expect(tokenIdent, "in"); err != nil {
+			return nil, err
+		}
+
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+
+		return Not(fieldIn(field, values)), nil
+	default:
+		return nil, p.errorf(tok, "expected comparison, got %q", tok.value)
+	}
+}
+
+// parseField parses: ident | "header" "[" string "]"
+func (p *predicateParser) parseField() (func(*govevents.Event) string, error) {
+	tok := p.next()
+	if tok.kind != tokenIdent {
+		return nil, p.errorf(tok, "expected field, got %q", tok.value)
+	}
+
+	if tok.value == "header" {
+		if err := p.expect(tokenOp, "["); err != nil {
+			return nil, err
+		}
+
+		key := p.next()
+		if key.kind != tokenString {
+			return nil, p.errorf(key, "expected header name, got %q", key.value)
+		}
+
+		if err := p.expect(tokenOp, "]"); err != nil {
+			return nil, err
+		}
+
+		return headerField(key.value), nil
+	}
+
+	field, ok := eventFields[tok.value]
+	if !ok {
+		return nil, p.errorf(tok, "unknown field %q", tok.value)
+	}
+
+	return field, nil
+}
+
+// parseList parses: "(" string ("," string)* ")"
+func (p *predicateParser) parseList() ([]string, error) {
+	if err := p.expect(tokenOp, "("); err != nil {
+		return nil, err
+	}
+
+	var values []string
+
+	for {
+		val := p.next()
+		if val.kind != tokenString {
+			return nil, p.errorf(val, "expected string, got %q", val.value)
+		}
+
+		values = append(values, val.value)
+
+		if !p.accept(tokenOp, ",") {
+			break
+		}
+	}
+
+	if err := p.expect(tokenOp, ")"); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
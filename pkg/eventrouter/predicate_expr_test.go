@@ -0,0 +1,97 @@
+package eventrouter
+
+import (
+	"testing"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePredicate(t *testing.T) {
+	event := &govevents.Event{
+		Action:  govevents.GovernorEventUpdate,
+		GroupID: "group-a",
+		ActorID: "actor-a",
+		Headers: map[string][]string{"X-Governor-Source": {"sync"}},
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		match bool
+	}{
+		{"equal", `action == "UPDATE"`, true},
+		{"not equal", `action != "UPDATE"`, false},
+		{"in", `group_id in ("group-b", "group-a")`, true},
+		{"not in", `group_id not in ("group-b", "group-a")`, false},
+		{"empty field", `user_id == ""`, true},
+		{"header", `header["X-Governor-Source"] == "sync"`, true},
+		{"missing header", `header["X-Other"] == ""`, true},
+		{"escaped quote", `actor_id == "actor-\"a\""`, false},
+		{"unicode escape", `actor_id == "actor\u002da"`, true},
+		{"single element list", `actor_id in ("actor-a")`, true},
+		{"negation", `!(actor_id == "actor-a")`, false},
+		{"double negation", `!!(actor_id == "actor-a")`, true},
+		{"negation binds tighter than and", `!action == "CREATE" && group_id == "group-a"`, true},
+		{"and binds tighter than or", `action == "CREATE" && group_id == "group-b" || actor_id == "actor-a"`, true},
+		{"and after or", `actor_id == "actor-a" || action == "CREATE" && group_id == "group-b"`, true},
+		{"parentheses", `(action == "CREATE" || actor_id == "actor-a") && group_id == "group-b"`, false},
+		{"nested parentheses", `((action == "UPDATE"))`, true},
+		{"whitespace", "  action==\"UPDATE\"\n&&\tgroup_id==\"group-a\"  ", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pred, err := ParsePredicate(tt.expr)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.match, pred(event))
+		})
+	}
+}
+
+func TestParsePredicateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"empty", ``},
+		{"unknown field", `owner_id == "a"`},
+		{"unterminated string", `action == "UPDATE`},
+		{"trailing escape", `action == "UPDATE\`},
+		{"invalid escape", `action == "\q"`},
+		{"unquoted value", `action == UPDATE`},
+		{"missing value", `action ==`},
+		{"missing comparison", `action`},
+		{"single equal", `action = "UPDATE"`},
+		{"unexpected character", `action == "UPDATE" & group_id == "a"`},
+		{"unbalanced open", `(action == "UPDATE"`},
+		{"unbalanced close", `action == "UPDATE")`},
+		{"empty parentheses", `()`},
+		{"dangling and", `action == "UPDATE" &&`},
+		{"dangling or", `|| action == "UPDATE"`},
+		{"dangling not", `!`},
+		{"empty list", `action in ()`},
+		{"unterminated list", `action in ("a", "b"`},
+		{"trailing comma", `action in ("a",)`},
+		{"list without parentheses", `action in "a"`},
+		{"not without in", `action not "a"`},
+		{"header without name", `header[] == "a"`},
+		{"header with ident name", `header[source] == "a"`},
+		{"unterminated header", `header["a" == "b"`},
+		{"string as field", `"action" == "UPDATE"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+
+			require.NotPanics(t, func() {
+				_, err = ParsePredicate(tt.expr)
+			})
+
+			assert.ErrorIs(t, err, ErrInvalidPredicate)
+		})
+	}
+}
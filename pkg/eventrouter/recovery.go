@@ -0,0 +1,69 @@
+package eventrouter
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// callHandler calls the handler and converts a panic into a *PanicError
+func callHandler(ctx context.Context, handler Handler, event *govevents.Event) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Value: p, Stack: debug.Stack()}
+		}
+	}()
+
+	return handler(ctx, event)
+}
+
+// mwRecover is a middleware that recovers from panics in handlers and converts
+// them into a *PanicError, so the panic is handled like any other
+// handler failure instead of crashing the extension. Panics recovered further
+// down the chain, e.g. in handlers running on their own goroutine, are
+// reported here as well. Panics in the global middlewares wrapping it are
+// recovered by Router.Process.
+func mwRecover(next Handler) Handler {
+	return func(ctx context.Context, event *govevents.Event) error {
+		err := callHandler(ctx, next, event)
+		if err == nil {
+			return nil
+		}
+
+		var perr *PanicError
+		if !errors.As(err, &perr) {
+			return err
+		}
+
+		reportPanic(ctx, event, perr)
+
+		return err
+	}
+}
+
+// reportPanic logs the recovered panic, records it on the event's span and
+// counts it
+func reportPanic(ctx context.Context, event *govevents.Event, perr *PanicError) {
+	subj := GetSubjectFromContext(ctx)
+
+	GetLoggerFromContext(ctx).Error(
+		"recovered from panic in event handler",
+		zap.Any("panic", perr.Value),
+		zap.ByteString("stack", perr.Stack),
+		zap.String("component", "recovery-middleware"),
+	)
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(perr, trace.WithAttributes(
+		attribute.String("exception.stacktrace", string(perr.Stack)),
+	))
+	span.SetStatus(codes.Error, perr.Error())
+
+	handlerPanicsTotal.WithLabelValues(subj, event.Action).Inc()
+}
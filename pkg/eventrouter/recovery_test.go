@@ -0,0 +1,103 @@
+package eventrouter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func panicMiddleware(Handler) Handler {
+	return func(context.Context, *govevents.Event) error {
+		panic("middleware panic")
+	}
+}
+
+func panicHandler(context.Context, *govevents.Event) error {
+	panic("handler panic")
+}
+
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		name    string
+		router  func() *Router
+		handler Handler
+		value   any
+		// seen processes the event once before, skip rules only apply to
+		// events whose correlation ID was seen
+		seen bool
+	}{
+		{
+			name:    "handler",
+			router:  func() *Router { return NewRouter() },
+			handler: panicHandler,
+			value:   "handler panic",
+		},
+		{
+			name:    "global middleware",
+			router:  func() *Router { return NewRouter(WithMiddleware(panicMiddleware)) },
+			handler: noopHandler,
+			value:   "middleware panic",
+		},
+		{
+			name: "middleware added with use",
+			router: func() *Router {
+				r := NewRouter()
+				r.Use(panicMiddleware)
+
+				return r
+			},
+			handler: noopHandler,
+			value:   "middleware panic",
+		},
+		{
+			name: "erd resolver",
+			router: func() *Router {
+				return NewRouter(WithCorrelationIDProcessor(NewCorrelationIDProcessor(
+					CorrelationIDProcessorWithSkipRules(SkipRule{Name: "erd", ERDs: []string{"users"}}),
+					CorrelationIDProcessorWithERDResolver(ERDResolverFunc(func(context.Context, string) (string, error) {
+						panic("resolver panic")
+					})),
+				)))
+			},
+			handler: noopHandler,
+			value:   "resolver panic",
+			seen:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.router()
+			r.Create("groups", tt.handler)
+
+			event := &govevents.Event{
+				Action:                        govevents.GovernorEventCreate,
+				ExtensionResourceDefinitionID: "erd-id",
+				Headers: map[string][]string{
+					govevents.GovernorEventCorrelationIDHeader: {"cid"},
+				},
+			}
+
+			if tt.seen {
+				require.NoError(t, r.Process(context.Background(), "groups", event))
+			}
+
+			var err error
+
+			require.NotPanics(t, func() {
+				err = r.Process(context.Background(), "groups", event)
+			})
+
+			require.ErrorIs(t, err, ErrHandlerPanic)
+
+			var perr *PanicError
+
+			require.True(t, errors.As(err, &perr))
+			assert.Equal(t, tt.value, perr.Value)
+		})
+	}
+}
@@ -3,21 +3,42 @@ package eventrouter
 
 import (
 	"context"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/trace"
 	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
 )
 
-// Router is the main router struct for mapping events to handlers
+// Router is the main router struct for mapping events to handlers. Routes
+// can be added and removed while the router is processing events.
 type Router struct {
-	routes                 map[string]map[string]Handler
+	mu                     sync.RWMutex
+	routes                 map[string]map[string]*route
 	mwchain                Middleware
+	middlewares            []string
 	correlationIDProcessor *CorrelationIDProcessor
+	listeners              []SubjectListener
+	defaultTimeout         time.Duration
+	abandoned              chan struct{}
+
+	// notifyMu serializes the route changes with the notifications of the
+	// subject listeners, so they are notified in the order of the changes
+	notifyMu sync.Mutex
 
 	tracer trace.Tracer
+	// traced is set when a tracer is configured, the trace context middleware
+	// is then applied once all the options are applied
+	traced bool
 	logger *zap.Logger
+
+	// registerer is the registerer the metrics are registered with, nil
+	// disables the registration
+	registerer prometheus.Registerer
 }
 
 // Option is a function that configures a Router
@@ -28,20 +49,37 @@ func NewRouter(opts ...Option) *Router {
 	r := &Router{
 		logger: zap.NewNop(),
 		tracer: noop.NewTracerProvider().Tracer("eventrouter"),
-		routes: make(map[string]map[string]Handler),
+		routes: make(map[string]map[string]*route),
 		mwchain: func(handler Handler) Handler {
 			return handler
 		},
+		abandoned:  make(chan struct{}, DefaultMaxAbandonedHandlers),
+		registerer: prometheus.DefaultRegisterer,
 	}
 
+	// recovery is applied first so that it is the innermost global middleware,
+	// this way a recovered panic is recorded on the event's processing span.
+	// Panics in the other global middlewares are recovered by Process.
+	r.applyGlobalMiddleware("recovery", mwRecover)
+
 	// apply options
 	for _, opt := range opts {
 		opt(r)
 	}
 
+	// the trace context is applied last so that it is the outermost global
+	// middleware, the skip decisions are recorded on the processing span
+	if r.traced {
+		r.applyGlobalMiddleware("trace-context", r.mwInjectTraceContext)
+	}
+
 	// set logger tags
 	r.logger = r.logger.With(zap.String("component", "eventrouter"))
 
+	if err := registerMetrics(r.registerer); err != nil {
+		r.logger.Warn("failed to register metrics", zap.Error(err))
+	}
+
 	return r
 }
 
@@ -52,11 +90,23 @@ func WithLogger(logger *zap.Logger) Option {
 	}
 }
 
-// WithTracer configures the tracer for the Router
+// WithMetricsRegisterer configures the registerer the eventrouter metrics are
+// registered with, the default is prometheus.DefaultRegisterer. A nil
+// registerer disables the registration.
+func WithMetricsRegisterer(reg prometheus.Registerer) Option {
+	return func(r *Router) {
+		r.registerer = reg
+	}
+}
+
+// WithTracer configures the tracer for the Router. The processing span is
+// started by the outermost global middleware, whatever the order of the
+// options, so the other middlewares, e.g. the correlation ID processor, record
+// their decisions on it.
 func WithTracer(tracer trace.Tracer) Option {
 	return func(r *Router) {
 		r.tracer = tracer
-		r.applyGlobalMiddleware(r.mwInjectTraceContext)
+		r.traced = true
 	}
 }
 
@@ -64,36 +114,155 @@ func WithTracer(tracer trace.Tracer) Option {
 func WithCorrelationIDProcessor(p *CorrelationIDProcessor) Option {
 	return func(r *Router) {
 		r.correlationIDProcessor = p
-		r.applyGlobalMiddleware(p.MWInjectCorrelationID)
+		r.applyGlobalMiddleware("correlation-id", p.MWInjectCorrelationID)
+	}
+}
+
+// WithIdempotencyProcessor configures the idempotency processor for the Router
+func WithIdempotencyProcessor(p *IdempotencyProcessor) Option {
+	return func(r *Router) {
+		r.applyGlobalMiddleware("idempotency", p.MWIdempotency)
+	}
+}
+
+// WithLoopDetector configures the loop detector for the Router. The detector
+// does nothing until the Governor API forwards the X-Governor-Extension-Hops
+// and X-Governor-Extension-Path headers to the events.
+func WithLoopDetector(ld *LoopDetector) Option {
+	return func(r *Router) {
+		r.applyGlobalMiddleware("loop-detection", ld.MWDetectLoops)
+	}
+}
+
+// WithDefaultTimeout configures the default timeout for handlers, the timeout
+// can be overridden per route with the Timeout middleware. A zero or negative
+// timeout disables the default timeout.
+func WithDefaultTimeout(d time.Duration) Option {
+	return func(r *Router) {
+		r.defaultTimeout = d
 	}
 }
 
 // WithMiddleware configures the middleware for the Router
 func WithMiddleware(mw Middleware) Option {
 	return func(r *Router) {
-		r.applyGlobalMiddleware(mw)
+		r.applyGlobalMiddleware(funcName(mw), mw)
 	}
 }
 
-func (r *Router) applyGlobalMiddleware(mw Middleware) {
+// applyGlobalMiddleware wraps the global middleware chain with mw, the last
+// applied middleware is executed first
+func (r *Router) applyGlobalMiddleware(name string, mw Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	next := r.mwchain
 	r.mwchain = func(handler Handler) Handler {
 		return mw(next(handler))
 	}
+
+	r.middlewares = append([]string{name}, r.middlewares...)
+
+	// the chain is compiled into every route ahead of time, so it does not
+	// have to be rebuilt for each event
+	for _, actions := range r.routes {
+		for _, rt := range actions {
+			rt.compiled = r.mwchain(rt.handler)
+		}
+	}
 }
 
 func (r *Router) addRoute(action, subj string, handler Handler, middlewares []Middleware) {
 	r.logger.Debug("adding route", zap.String("action", action), zap.String("subject", subj))
 
-	if _, ok := r.routes[subj]; !ok {
-		r.routes[subj] = make(map[string]Handler)
+	rt := &route{
+		name:        funcName(handler),
+		middlewares: make([]string, len(middlewares)),
 	}
 
-	for _, mw := range middlewares {
+	// the default timeout wraps the handler before the route middlewares, so
+	// a Timeout route middleware takes precedence
+	handler = r.mwDefaultTimeout(handler)
+
+	// the last route middleware is executed first
+	for i, mw := range middlewares {
 		handler = mw(handler)
+		rt.middlewares[len(middlewares)-1-i] = funcName(mw)
 	}
 
-	r.routes[subj][action] = handler
+	rt.handler = handler
+
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+
+	r.mu.Lock()
+
+	_, exists := r.routes[subj]
+	if !exists {
+		r.routes[subj] = make(map[string]*route)
+	}
+
+	rt.compiled = r.mwchain(rt.handler)
+	r.routes[subj][action] = rt
+	listeners := r.listeners
+
+	r.mu.Unlock()
+
+	// listeners are notified outside of the route lock so they are free to
+	// read the router
+	if !exists {
+		for _, l := range listeners {
+			l.SubjectAdded(subj)
+		}
+	}
+}
+
+// Remove removes the handler for the action on the subject. Once the last
+// handler of a subject is removed, the subject is no longer reported by
+// Subjects and subject listeners are notified.
+func (r *Router) Remove(action, subj string) {
+	r.logger.Debug("removing route", zap.String("action", action), zap.String("subject", subj))
+
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+
+	r.mu.Lock()
+
+	actions, ok := r.routes[subj]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+
+	delete(actions, action)
+
+	removed := len(actions) == 0
+	if removed {
+		delete(r.routes, subj)
+	}
+
+	listeners := r.listeners
+
+	r.mu.Unlock()
+
+	if removed {
+		for _, l := range listeners {
+			l.SubjectRemoved(subj)
+		}
+	}
+}
+
+// AddSubjectListener registers a listener that is notified when subjects are
+// added to or removed from the router. Subjects registered before the
+// listener was added are not replayed, use Subjects to list them.
+func (r *Router) AddSubjectListener(l SubjectListener) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// copy on write, so listeners can be iterated without holding the lock
+	listeners := make([]SubjectListener, 0, len(r.listeners)+1)
+	listeners = append(listeners, r.listeners...)
+	r.listeners = append(listeners, l)
 }
 
 // Create adds a handler for the create event
@@ -127,36 +296,67 @@ func (r *Router) Revoke(subj string, handler Handler, middlewares ...Middleware)
 }
 
 // Process function finds the event handler for the event and executes it
-func (r *Router) Process(ctx context.Context, subj string, event *govevents.Event) error {
-	r.logger.Info(
-		"processing event",
-		zap.String("resource-id", event.ExtensionResourceID),
-		zap.String("action", event.Action),
-		zap.String("subject", subj),
-	)
-
-	if _, ok := r.routes[subj]; !ok {
+func (r *Router) Process(ctx context.Context, subj string, event *govevents.Event) (err error) {
+	// check the level first so the fields are not allocated when info logging
+	// is disabled
+	if ce := r.logger.Check(zap.InfoLevel, "processing event"); ce != nil {
+		ce.Write(
+			zap.String("resource-id", event.ExtensionResourceID),
+			zap.String("action", event.Action),
+			zap.String("subject", subj),
+		)
+	}
+
+	r.mu.RLock()
+
+	actions, ok := r.routes[subj]
+	if !ok {
+		r.mu.RUnlock()
 		return ErrHandlerNotFound
 	}
 
-	ctx = SaveSubjectToContext(ctx, subj)
+	rt, ok := actions[event.Action]
 
-	if handler, ok := r.routes[subj][event.Action]; ok {
-		return r.mwchain(handler)(ctx, event)
+	var handler Handler
+	if ok {
+		handler = rt.compiled
 	}
 
-	return nil
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	ec := newEventContext(ctx, subj, event, r.logger)
+	ec.abandoned = r.abandoned
+	ctx = ec
+
+	// the global middlewares wrap the recovery middleware, so their panics
+	// are recovered here
+	defer func() {
+		if p := recover(); p != nil {
+			perr := &PanicError{Value: p, Stack: debug.Stack()}
+			reportPanic(ctx, event, perr)
+			err = perr
+		}
+	}()
+
+	return handler(ctx, event)
 }
 
 // Use adds a global middleware to the Router. This function can be used after
 // the Router has been created.
 func (r *Router) Use(mw Middleware) {
-	r.applyGlobalMiddleware(mw)
+	r.applyGlobalMiddleware(funcName(mw), mw)
 }
 
 // Subjects returns a list of subjects that have been registered with the
 // router
 func (r *Router) Subjects() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	subjs := make([]string, 0, len(r.routes))
 
 	for subj := range r.routes {
@@ -166,5 +366,13 @@ func (r *Router) Subjects() []string {
 	return subjs
 }
 
-// Router implements EventRouter interface
-var _ EventRouter = (*Router)(nil)
+var (
+	// Router implements EventRouter interface
+	_ EventRouter = (*Router)(nil)
+	// Router implements SubjectNotifier interface
+	_ SubjectNotifier = (*Router)(nil)
+	// Router implements RouteRemover interface
+	_ RouteRemover = (*Router)(nil)
+	// Router implements Introspector interface
+	_ Introspector = (*Router)(nil)
+)
@@ -0,0 +1,140 @@
+package eventrouter
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+)
+
+func noopHandler(context.Context, *govevents.Event) error { return nil }
+
+func noopMiddleware(next Handler) Handler {
+	return func(ctx context.Context, event *govevents.Event) error {
+		return next(ctx, event)
+	}
+}
+
+func benchmarkEvent(cid string) *govevents.Event {
+	ev := &govevents.Event{
+		Action:              govevents.GovernorEventUpdate,
+		ExtensionResourceID: "00000000-0000-0000-0000-000000000001",
+	}
+
+	if cid != "" {
+		ev.Headers = map[string][]string{
+			govevents.GovernorEventCorrelationIDHeader: {cid},
+		}
+	}
+
+	return ev
+}
+
+func BenchmarkRouterProcess(b *testing.B) {
+	r := NewRouter()
+	r.Update("greetings", noopHandler)
+
+	ctx := context.Background()
+	ev := benchmarkEvent("")
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if err := r.Process(ctx, "greetings", ev); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRouterProcessManyRoutes(b *testing.B) {
+	r := NewRouter()
+
+	for i := range 100 {
+		r.Update("subject-"+strconv.Itoa(i), noopHandler)
+	}
+
+	ctx := context.Background()
+	ev := benchmarkEvent("")
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if err := r.Process(ctx, "subject-50", ev); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRouterProcessMiddlewares(b *testing.B) {
+	r := NewRouter()
+	r.Use(noopMiddleware)
+	r.Use(noopMiddleware)
+	r.Update("greetings", noopHandler, noopMiddleware, noopMiddleware)
+
+	ctx := context.Background()
+	ev := benchmarkEvent("")
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if err := r.Process(ctx, "greetings", ev); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRouterProcessParallel(b *testing.B) {
+	r := NewRouter()
+	r.Update("greetings", noopHandler)
+
+	ctx := context.Background()
+
+	b.ReportAllocs()
+
+	b.RunParallel(func(pb *testing.PB) {
+		ev := benchmarkEvent("")
+
+		for pb.Next() {
+			if err := r.Process(ctx, "greetings", ev); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkRouterProcessCorrelationID(b *testing.B) {
+	p := NewCorrelationIDProcessor()
+	r := NewRouter(WithCorrelationIDProcessor(p))
+	r.Update("greetings", noopHandler)
+
+	ctx := context.Background()
+	ev := benchmarkEvent("b7d6f0f6-4f0b-4a6c-8d4e-7d3a1c2b9e10")
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if err := r.Process(ctx, "greetings", ev); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCorrelationIDProcessorShouldSkip(b *testing.B) {
+	p := NewCorrelationIDProcessor(
+		CorrelationIDProcessorWithHistoryCache(historycache.NewLocalCache()),
+	)
+
+	ctx := context.Background()
+	cid := "b7d6f0f6-4f0b-4a6c-8d4e-7d3a1c2b9e10"
+
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if _, _, err := p.ShouldSkip(ctx, cid, govevents.GovernorEventUpdate, "greetings"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
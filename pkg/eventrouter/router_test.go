@@ -0,0 +1,64 @@
+package eventrouter
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+// lastSubjectListener records whether the last notification of each subject
+// added it, the added notifications are slow
+type lastSubjectListener struct {
+	mu    sync.Mutex
+	added map[string]bool
+}
+
+func (l *lastSubjectListener) SubjectAdded(subj string) {
+	time.Sleep(time.Millisecond)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.added[subj] = true
+}
+
+func (l *lastSubjectListener) SubjectRemoved(subj string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.added[subj] = false
+}
+
+func TestSubjectListenerOrder(t *testing.T) {
+	r := NewRouter()
+	l := &lastSubjectListener{added: map[string]bool{}}
+	r.AddSubjectListener(l)
+
+	handler := func(context.Context, *govevents.Event) error { return nil }
+
+	for range 10 {
+		var wg sync.WaitGroup
+
+		wg.Go(func() { r.Create("groups", handler) })
+		wg.Go(func() {
+			// removed while the listener is notified of the addition
+			for !slices.Contains(r.Subjects(), "groups") {
+				runtime.Gosched()
+			}
+
+			r.Remove(govevents.GovernorEventCreate, "groups")
+		})
+		wg.Wait()
+
+		// the listener is notified in the order of the changes
+		l.mu.Lock()
+		assert.Equal(t, slices.Contains(r.Subjects(), "groups"), l.added["groups"])
+		l.mu.Unlock()
+	}
+}
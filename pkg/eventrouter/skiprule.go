@@ -0,0 +1,172 @@
+package eventrouter
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+)
+
+// SkipRule is a rule of the skip strategy of CorrelationIDProcessor, deciding
+// whether an event recognized as caused by the extension can be skipped. Every
+// condition set must match for the rule to match, an empty condition matches
+// any event.
+type SkipRule struct {
+	// Name identifies the rule in logs and metrics, it defaults to
+	// rule-<index>
+	Name string
+	// Actions are the event actions matched by the rule
+	Actions []string
+	// Subjects are glob patterns matched against the event subject, e.g.
+	// "groups", "extension-resources.*" or "*", see path.Match
+	Subjects []string
+	// ERDs are the slugs or IDs of the ERDs of the extension resources
+	// matched by the rule, slugs are resolved with the ERD resolver of the
+	// processor
+	ERDs []string
+	// Predicate is matched against the event
+	Predicate Predicate
+	// Never makes the matching events never skipped, whatever the other
+	// rules. Never rules are evaluated before the other rules.
+	Never bool
+}
+
+// ERDResolver resolves the slug of an ERD from its ID
+type ERDResolver interface {
+	ERDSlug(ctx context.Context, erdID string) (string, error)
+}
+
+// ERDResolverFunc is a function implementing ERDResolver
+type ERDResolverFunc func(ctx context.Context, erdID string) (string, error)
+
+// ERDSlug resolves the slug of an ERD from its ID
+func (f ERDResolverFunc) ERDSlug(ctx context.Context, erdID string) (string, error) {
+	return f(ctx, erdID)
+}
+
+// matches reports whether the rule matches the event on the subject
+func (r *SkipRule) matches(ctx context.Context, resolver ERDResolver, subj string, event *govevents.Event) (bool, error) {
+	if len(r.Actions) > 0 && !slices.Contains(r.Actions, event.Action) {
+		return false, nil
+	}
+
+	if len(r.Subjects) > 0 && !subjectMatches(r.Subjects, subj) {
+		return false, nil
+	}
+
+	if len(r.ERDs) > 0 {
+		ok, err := r.erdMatches(ctx, resolver, event.ExtensionResourceDefinitionID)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	if r.Predicate != nil && !r.Predicate(event) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// erdMatches reports whether the ERD ID, or its slug, is one of the rule ERDs
+func (r *SkipRule) erdMatches(ctx context.Context, resolver ERDResolver, erdID string) (bool, error) {
+	if erdID == "" {
+		return false, nil
+	}
+
+	if slices.Contains(r.ERDs, erdID) {
+		return true, nil
+	}
+
+	if resolver == nil {
+		return false, nil
+	}
+
+	slug, err := resolver.ERDSlug(ctx, erdID)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(r.ERDs, slug), nil
+}
+
+// subjectMatches reports whether the subject matches one of the patterns
+func subjectMatches(patterns []string, subj string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == subj {
+			return true
+		}
+
+		if ok, err := path.Match(p, subj); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchSkipRules returns the name of the rule deciding whether the event can
+// be skipped and whether it can be skipped. Never rules take precedence, then
+// the first matching rule wins.
+func matchSkipRules(ctx context.Context, rules []SkipRule, resolver ERDResolver, subj string, event *govevents.Event) (string, bool, error) {
+	for _, never := range []bool{true, false} {
+		for i := range rules {
+			if rules[i].Never != never {
+				continue
+			}
+
+			ok, err := rules[i].matches(ctx, resolver, subj, event)
+			if err != nil {
+				return "", false, err
+			}
+
+			if ok {
+				return ruleName(rules, i), !never, nil
+			}
+		}
+	}
+
+	return "", false, nil
+}
+
+// ruleName returns the name of the rule at index i, a rule matching an event
+// always has a name
+func ruleName(rules []SkipRule, i int) string {
+	if rules[i].Name == "" {
+		return "rule-" + strconv.Itoa(i)
+	}
+
+	return rules[i].Name
+}
+
+// skipRulesFromRoutes converts a map of skippable routes, keyed by action and
+// subject, into rules with the name
+func skipRulesFromRoutes(name string, routes map[string]map[string]struct{}) []SkipRule {
+	rules := make([]SkipRule, 0, len(routes))
+
+	for action, subjs := range routes {
+		rule := SkipRule{Name: name, Actions: []string{action}}
+
+		for subj := range subjs {
+			rule.Subjects = append(rule.Subjects, subj)
+		}
+
+		// an empty subject set never matched any route
+		if len(rule.Subjects) == 0 {
+			continue
+		}
+
+		sort.Strings(rule.Subjects)
+
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Actions[0] < rules[j].Actions[0]
+	})
+
+	return rules
+}
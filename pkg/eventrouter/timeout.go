@@ -0,0 +1,187 @@
+package eventrouter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// DefaultMaxAbandonedHandlers is the default maximum number of handlers that
+// keep running in the background after their timeout expired
+const DefaultMaxAbandonedHandlers = 100
+
+// Timeout returns a middleware that cancels the handler context after d and
+// returns an error wrapping ErrHandlerTimeout.
+//
+// The middleware returns as soon as the timeout expires, even if the handler
+// does not respect the context cancellation, so a stuck handler does not hold
+// on to a worker. The handler is abandoned and keeps running in the
+// background until it returns, its late result is logged and counted but
+// otherwise discarded, so its side effects may land after the timeout was
+// reported. Once the router's limit of abandoned handlers is reached, see
+// WithMaxAbandonedHandlers, the middleware waits for the handler to return
+// instead.
+//
+// When the parent context is done before the timeout expires, the parent's
+// error is returned instead of ErrHandlerTimeout.
+//
+// When used as a route middleware, Timeout overrides the router's default
+// timeout set with WithDefaultTimeout.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, event *govevents.Event) error {
+			return callWithTimeout(ctx, d, next, event)
+		}
+	}
+}
+
+// WithMaxAbandonedHandlers sets the maximum number of handlers that keep
+// running in the background after their timeout expired, the timeout waits for
+// the handler to return once it is reached. A zero or negative maximum never
+// abandons handlers. Defaults to DefaultMaxAbandonedHandlers.
+func WithMaxAbandonedHandlers(n int) Option {
+	return func(r *Router) {
+		r.abandoned = make(chan struct{}, max(n, 0))
+	}
+}
+
+// mwDefaultTimeout returns a middleware that applies the router's default
+// timeout, unless a timeout was already applied further up the chain
+func (r *Router) mwDefaultTimeout(next Handler) Handler {
+	return func(ctx context.Context, event *govevents.Event) error {
+		if r.defaultTimeout <= 0 || GetTimeoutFromContext(ctx) != 0 {
+			return next(ctx, event)
+		}
+
+		return callWithTimeout(ctx, r.defaultTimeout, next, event)
+	}
+}
+
+func callWithTimeout(parent context.Context, d time.Duration, next Handler, event *govevents.Event) error {
+	ctx, cancel := context.WithTimeout(parent, d)
+	defer cancel()
+
+	ctx = context.WithValue(ctx, timeoutCtxKey, d)
+
+	// buffered, so the handler goroutine never blocks if the result is
+	// abandoned after the timeout
+	done := make(chan error, 1)
+
+	go func() {
+		done <- callHandler(ctx, next, event)
+	}()
+
+	select {
+	case err := <-done:
+		// the parent's deadline is not the handler's timeout
+		if err == nil || parent.Err() != nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return err
+		}
+
+		return reportTimeout(ctx, d, event, fmt.Errorf("%w after %s: %w", ErrHandlerTimeout, d, err))
+	case <-ctx.Done():
+	}
+
+	abandonHandler(ctx, event, done)
+
+	if err := parent.Err(); err != nil {
+		return err
+	}
+
+	return reportTimeout(ctx, d, event, fmt.Errorf("%w after %s", ErrHandlerTimeout, d))
+}
+
+// reportTimeout logs the timeout, records it on the event's span and counts it
+func reportTimeout(ctx context.Context, d time.Duration, event *govevents.Event, err error) error {
+	GetLoggerFromContext(ctx).Warn(
+		"event handler timed out",
+		zap.Duration("timeout", d),
+		zap.String("component", "timeout-middleware"),
+	)
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("event.timeout", d.String()))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	handlerTimeoutsTotal.WithLabelValues(GetSubjectFromContext(ctx), event.Action).Inc()
+
+	return err
+}
+
+// abandonHandler leaves the handler running in the background and reports its
+// late result once it returns. When the router's limit of abandoned handlers
+// is reached, it waits for the handler instead, so handlers ignoring their
+// context cannot pile up beyond the router's workers and the limit.
+func abandonHandler(ctx context.Context, event *govevents.Event, done <-chan error) {
+	limit, _ := ctx.Value(abandonedCtxKey).(chan struct{})
+
+	if limit != nil {
+		select {
+		case limit <- struct{}{}:
+		default:
+			GetLoggerFromContext(ctx).Warn(
+				"too many abandoned event handlers, waiting for the handler to return",
+				zap.Int("max-abandoned-handlers", cap(limit)),
+				zap.String("component", "timeout-middleware"),
+			)
+
+			reportLateResult(ctx, event, <-done)
+
+			return
+		}
+	}
+
+	handlersAbandoned.Inc()
+
+	go func() {
+		err := <-done
+
+		handlersAbandoned.Dec()
+
+		if limit != nil {
+			<-limit
+		}
+
+		reportLateResult(ctx, event, err)
+	}()
+}
+
+// reportLateResult logs and counts the result of a handler that returned
+// after its timeout
+func reportLateResult(ctx context.Context, event *govevents.Event, err error) {
+	logger := GetLoggerFromContext(ctx).With(zap.String("component", "timeout-middleware"))
+
+	var (
+		perr   *PanicError
+		result string
+	)
+
+	switch {
+	case err == nil:
+		result = "success"
+
+		logger.Warn("timed out event handler succeeded after its timeout")
+	case errors.As(err, &perr):
+		result = "panic"
+
+		logger.Error(
+			"recovered from panic in timed out event handler",
+			zap.Any("panic", perr.Value),
+			zap.ByteString("stack", perr.Stack),
+		)
+	default:
+		result = "error"
+
+		logger.Warn("timed out event handler failed after its timeout", zap.Error(err))
+	}
+
+	abandonedHandlerResultsTotal.WithLabelValues(GetSubjectFromContext(ctx), event.Action, result).Inc()
+}
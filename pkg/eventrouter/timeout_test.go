@@ -0,0 +1,93 @@
+package eventrouter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var errLate = errors.New("late failure")
+
+func TestTimeoutLateResults(t *testing.T) {
+	tests := []struct {
+		name   string
+		result func() error
+		msg    string
+	}{
+		{"success", func() error { return nil }, "timed out event handler succeeded after its timeout"},
+		{"error", func() error { return errLate }, "timed out event handler failed after its timeout"},
+		{"panic", func() error { panic(errLate) }, "recovered from panic in timed out event handler"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subj := "timeout-late-" + tt.name
+			release := make(chan struct{})
+
+			core, logs := observer.New(zap.DebugLevel)
+
+			r := NewRouter(WithLogger(zap.New(core)))
+			r.Create(subj, func(context.Context, *govevents.Event) error {
+				<-release
+				return tt.result()
+			}, Timeout(time.Millisecond))
+
+			err := r.Process(context.Background(), subj, &govevents.Event{Action: govevents.GovernorEventCreate})
+			require.ErrorIs(t, err, ErrHandlerTimeout)
+
+			// the handler is still running in the background
+			assert.Len(t, r.abandoned, 1)
+
+			close(release)
+
+			// the late result is reported once the handler returns
+			require.Eventually(t, func() bool {
+				return logs.FilterMessage(tt.msg).Len() == 1
+			}, time.Second, time.Millisecond)
+
+			assert.Empty(t, r.abandoned)
+		})
+	}
+}
+
+func TestTimeoutParentDeadline(t *testing.T) {
+	r := NewRouter()
+	r.Create("timeout-parent", func(context.Context, *govevents.Event) error {
+		time.Sleep(50 * time.Millisecond) //nolint: mnd
+		return nil
+	}, Timeout(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	err := r.Process(ctx, "timeout-parent", &govevents.Event{Action: govevents.GovernorEventCreate})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrHandlerTimeout)
+}
+
+func TestTimeoutMaxAbandonedHandlers(t *testing.T) {
+	var returned atomic.Bool
+
+	r := NewRouter(WithMaxAbandonedHandlers(0))
+	r.Create("timeout-max-abandoned", func(context.Context, *govevents.Event) error {
+		// ignores the context cancellation
+		time.Sleep(20 * time.Millisecond) //nolint: mnd
+		returned.Store(true)
+
+		return nil
+	}, Timeout(time.Millisecond))
+
+	err := r.Process(context.Background(), "timeout-max-abandoned", &govevents.Event{Action: govevents.GovernorEventCreate})
+	require.ErrorIs(t, err, ErrHandlerTimeout)
+
+	// the handler is not abandoned, the timeout waited for it
+	assert.True(t, returned.Load())
+}
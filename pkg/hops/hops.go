@@ -0,0 +1,129 @@
+// Package hops tracks the extensions an event went through, so loops between
+// extensions updating each other's resources can be detected.
+//
+// Every time an extension handles an event and writes to the Governor API,
+// the hop count is incremented and the extension ID is appended to the path.
+// The hops are sent in request headers by the roundtripper and are expected to
+// be forwarded by the Governor API to the headers of the resulting events,
+// like the correlation ID.
+package hops
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// CountHeader is the header carrying the number of hops
+	CountHeader = "X-Governor-Extension-Hops"
+	// PathHeader is the header carrying the comma separated IDs of the
+	// extensions visited
+	PathHeader = "X-Governor-Extension-Path"
+)
+
+type ctxKey struct{}
+
+// Hops describes the extensions an event went through
+type Hops struct {
+	// Count is the number of hops
+	Count int
+	// Path is the IDs of the extensions visited, in order
+	Path []string
+}
+
+// HeaderGetter reads a header value, it is implemented by http.Header and by
+// nats.Header, which the headers of an event are read with
+type HeaderGetter interface {
+	Get(key string) string
+}
+
+// FromHeader parses the hops from headers, e.g. the headers of an event read
+// with nats.Header, like its correlation ID. A missing or malformed count is
+// treated as 0.
+func FromHeader(h HeaderGetter) Hops {
+	var hops Hops
+
+	if v := h.Get(CountHeader); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			hops.Count = n
+		}
+	}
+
+	if v := h.Get(PathHeader); v != "" {
+		for id := range strings.SplitSeq(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				hops.Path = append(hops.Path, id)
+			}
+		}
+	}
+
+	return hops
+}
+
+// SetHeader sets the hop headers, nothing is set for zero hops
+func (h Hops) SetHeader(header http.Header) {
+	if h.IsZero() {
+		return
+	}
+
+	header.Set(CountHeader, strconv.Itoa(h.Count))
+
+	if len(h.Path) > 0 {
+		header.Set(PathHeader, strings.Join(h.Path, ","))
+	}
+}
+
+// Next returns the hops after the extension handled the event, an empty
+// extension ID is only counted
+func (h Hops) Next(extensionID string) Hops {
+	next := Hops{Count: h.Count + 1, Path: slices.Clone(h.Path)}
+
+	if extensionID != "" {
+		next.Path = append(next.Path, extensionID)
+	}
+
+	return next
+}
+
+// Visited reports whether the extension is in the path
+func (h Hops) Visited(extensionID string) bool {
+	return slices.Contains(h.Path, extensionID)
+}
+
+// LoopsThrough reports whether the path went through the extension and then
+// through another extension, i.e. the event came back to the extension through
+// another one. An event caused by the extension's own write, whose path ends
+// with the extension, is not a loop.
+func (h Hops) LoopsThrough(extensionID string) bool {
+	i := slices.Index(h.Path, extensionID)
+	if i < 0 {
+		return false
+	}
+
+	return slices.ContainsFunc(h.Path[i+1:], func(id string) bool {
+		return id != extensionID
+	})
+}
+
+// IsZero reports whether there are no hops
+func (h Hops) IsZero() bool {
+	return h.Count == 0 && len(h.Path) == 0
+}
+
+// Inject returns a context with the hops
+func Inject(ctx context.Context, h Hops) context.Context {
+	return context.WithValue(ctx, ctxKey{}, h)
+}
+
+// Extract returns the hops in the context, zero hops if there are none
+func Extract(ctx context.Context) Hops {
+	h, ok := ctx.Value(ctxKey{}).(Hops)
+	if !ok {
+		return Hops{}
+	}
+
+	return h
+}
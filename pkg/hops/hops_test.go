@@ -0,0 +1,97 @@
+package hops
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header HeaderGetter
+		want   Hops
+	}{
+		{"empty", http.Header{}, Hops{}},
+		{"count and path", http.Header{CountHeader: {"2"}, PathHeader: {"ext-a,ext-b"}}, Hops{Count: 2, Path: []string{"ext-a", "ext-b"}}},
+		{"spaces and empty path elements", http.Header{PathHeader: {" ext-a, ,ext-b,"}}, Hops{Path: []string{"ext-a", "ext-b"}}},
+		{"malformed count", http.Header{CountHeader: {"two"}}, Hops{}},
+		{"negative count", http.Header{CountHeader: {"-1"}}, Hops{}},
+		{"event header", nats.Header{CountHeader: {"1"}, PathHeader: {"ext-a"}}, Hops{Count: 1, Path: []string{"ext-a"}}},
+		{"event header keys are case sensitive", nats.Header{"x-governor-extension-hops": {"1"}}, Hops{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, FromHeader(tt.header))
+		})
+	}
+}
+
+func TestSetHeader(t *testing.T) {
+	header := http.Header{}
+	Hops{}.SetHeader(header)
+	assert.Empty(t, header)
+
+	Hops{Count: 2, Path: []string{"ext-a", "ext-b"}}.SetHeader(header)
+	assert.Equal(t, "2", header.Get(CountHeader))
+	assert.Equal(t, "ext-a,ext-b", header.Get(PathHeader))
+
+	// the headers round trip
+	assert.Equal(t, Hops{Count: 2, Path: []string{"ext-a", "ext-b"}}, FromHeader(header))
+}
+
+func TestNext(t *testing.T) {
+	h := Hops{Count: 1, Path: []string{"ext-a"}}
+
+	next := h.Next("ext-b")
+	assert.Equal(t, Hops{Count: 2, Path: []string{"ext-a", "ext-b"}}, next)
+
+	// the original path is not modified
+	assert.Equal(t, []string{"ext-a"}, h.Path)
+
+	// an empty extension ID is only counted
+	assert.Equal(t, Hops{Count: 2, Path: []string{"ext-a"}}, h.Next(""))
+}
+
+func TestLoopsThrough(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  []string
+		loops bool
+	}{
+		{"empty path", nil, false},
+		{"not visited", []string{"ext-b", "ext-c"}, false},
+		{"own write", []string{"ext-a"}, false},
+		{"own writes", []string{"ext-a", "ext-a"}, false},
+		{"own write after another extension", []string{"ext-b", "ext-a"}, false},
+		{"through another extension", []string{"ext-a", "ext-b"}, true},
+		{"back to the extension", []string{"ext-a", "ext-b", "ext-a"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Hops{Count: len(tt.path), Path: tt.path}
+
+			assert.Equal(t, tt.loops, h.LoopsThrough("ext-a"))
+		})
+	}
+}
+
+func TestVisited(t *testing.T) {
+	h := Hops{Count: 2, Path: []string{"ext-a", "ext-b"}}
+
+	assert.True(t, h.Visited("ext-a"))
+	assert.True(t, h.Visited("ext-b"))
+	assert.False(t, h.Visited("ext-c"))
+}
+
+func TestContext(t *testing.T) {
+	assert.True(t, Extract(context.Background()).IsZero())
+
+	h := Hops{Count: 1, Path: []string{"ext-a"}}
+	assert.Equal(t, h, Extract(Inject(context.Background(), h)))
+}
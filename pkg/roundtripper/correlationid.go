@@ -0,0 +1,101 @@
+package roundtripper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// WriteRecorder records the correlation IDs of the write requests the
+// extension sent to the Governor API, so the events they cause can be
+// recognized as self-originated. Writes are recorded before their request is
+// sent, since Governor may publish the event before it responds, and removed
+// when the request fails. It is implemented by
+// eventrouter.CorrelationIDProcessor.
+type WriteRecorder interface {
+	RecordWrite(ctx context.Context, cid, resourceID string) error
+	RemoveWrite(ctx context.Context, cid, resourceID string) error
+}
+
+// CorrelationIDEnsurer returns a context with a correlation ID, generating
+// and registering a new one if the context has none. It is implemented by
+// eventrouter.CorrelationIDProcessor.
+type CorrelationIDEnsurer interface {
+	EnsureCorrelationID(ctx context.Context) (context.Context, string, error)
+}
+
+// ResourceIDFunc returns the ID of the resource a write request is sent for,
+// or an empty string if it is not known
+type ResourceIDFunc func(req *http.Request) string
+
+// CorrelationIDOption is a function that configures the correlation ID
+// roundtripper.
+type CorrelationIDOption func(*correlationIDConfig)
+
+type correlationIDConfig struct {
+	recorder   WriteRecorder
+	ensurer    CorrelationIDEnsurer
+	resourceID ResourceIDFunc
+}
+
+// CorrelationIDWithWriteRecorder records the correlation ID and resource ID of
+// every write request, i.e. POST, PUT, PATCH and DELETE requests, with the
+// recorder. The write is removed again when the request fails or its response
+// is not successful.
+func CorrelationIDWithWriteRecorder(r WriteRecorder) CorrelationIDOption {
+	return func(c *correlationIDConfig) {
+		c.recorder = r
+	}
+}
+
+// CorrelationIDWithGeneration generates a correlation ID with the ensurer for
+// requests whose context has none, e.g. requests made from reconciliation
+// loops or HTTP handlers, so the events they cause can be recognized as
+// self-originated.
+func CorrelationIDWithGeneration(e CorrelationIDEnsurer) CorrelationIDOption {
+	return func(c *correlationIDConfig) {
+		c.ensurer = e
+	}
+}
+
+// CorrelationIDWithResourceIDFunc sets the function returning the resource ID
+// of a write request, the default is DefaultResourceID.
+func CorrelationIDWithResourceIDFunc(fn ResourceIDFunc) CorrelationIDOption {
+	return func(c *correlationIDConfig) {
+		c.resourceID = fn
+	}
+}
+
+// DefaultResourceID returns the last UUID in the request path, e.g. the
+// extension resource ID of
+// /api/v1alpha1/extension-resources/{extension}/{erd}/{version}/{id}. Requests
+// creating a resource have no ID in their path, their writes match any
+// resource.
+func DefaultResourceID(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		if err := uuid.Validate(segments[i]); err == nil {
+			return segments[i]
+		}
+	}
+
+	return ""
+}
+
+// isWrite reports whether the request changes resources in the Governor API
+func isWrite(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSuccess reports whether the response has a 2xx status code
+func isSuccess(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices
+}
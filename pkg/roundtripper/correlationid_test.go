@@ -0,0 +1,104 @@
+package roundtripper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	events "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testResourceID = "9e4f3a6e-3c2f-4a4b-9d7e-1f0b2c3d4e5f"
+
+var errTransport = errors.New("transport error")
+
+func TestCorrelationIDRecordsWriteBeforeResponse(t *testing.T) {
+	ctx := context.Background()
+
+	cidp := eventrouter.NewCorrelationIDProcessor(eventrouter.CorrelationIDProcessorWithSelfOriginatedOnly())
+
+	event := &events.Event{
+		Action:              events.GovernorEventUpdate,
+		ExtensionResourceID: testResourceID,
+		Headers: map[string][]string{
+			events.GovernorEventCorrelationIDHeader: {"cid"},
+		},
+	}
+
+	var skipped bool
+
+	// Governor publishes the event while the write request is in flight
+	base := func(*http.Request) (*http.Response, error) {
+		var err error
+
+		skipped, _, err = cidp.ShouldSkipEvent(ctx, "extension-resources", event)
+		require.NoError(t, err)
+
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	rt := NewGovExtRoundTripper(base, WithCorrelationID(CorrelationIDWithWriteRecorder(cidp)))
+
+	_, err := rt.RoundTrip(newWriteRequest(t, "cid"))
+	require.NoError(t, err)
+
+	assert.True(t, skipped)
+}
+
+func TestCorrelationIDRemovesFailedWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *http.Response
+		err  error
+	}{
+		{name: "transport error", err: errTransport},
+		{name: "unsuccessful response", resp: &http.Response{StatusCode: http.StatusInternalServerError}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			cidp := eventrouter.NewCorrelationIDProcessor(eventrouter.CorrelationIDProcessorWithSelfOriginatedOnly())
+
+			base := func(*http.Request) (*http.Response, error) {
+				return tt.resp, tt.err
+			}
+
+			rt := NewGovExtRoundTripper(base, WithCorrelationID(CorrelationIDWithWriteRecorder(cidp)))
+
+			_, err := rt.RoundTrip(newWriteRequest(t, "cid"))
+			assert.ErrorIs(t, err, tt.err)
+
+			skip, _, err := cidp.WouldSkipEvent(ctx, "extension-resources", &events.Event{
+				Action:              events.GovernorEventUpdate,
+				ExtensionResourceID: testResourceID,
+				Headers: map[string][]string{
+					events.GovernorEventCorrelationIDHeader: {"cid"},
+				},
+			})
+			require.NoError(t, err)
+			assert.False(t, skip)
+		})
+	}
+}
+
+// newWriteRequest returns a write request to the test resource in the context
+// of the correlation ID
+func newWriteRequest(t *testing.T, cid string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(
+		events.InjectCorrelationID(context.Background(), cid),
+		http.MethodPut,
+		"http://governor/api/v1alpha1/extension-resources/ext/erd/v1/"+testResourceID,
+		nil,
+	)
+	require.NoError(t, err)
+
+	return req
+}
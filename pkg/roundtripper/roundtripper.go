@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	events "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/hops"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
@@ -66,6 +68,27 @@ func WithTraceContext() Option {
 	}
 }
 
+// WithHops injects the hops of the event being handled into the outgoing
+// request headers, so loops between extensions can be detected.
+func WithHops() Option {
+	return func(rt *GovExtRoundTripper) {
+		next := rt.roundtripperChain
+		rt.roundtripperChain = func(req *http.Request) (*http.Response, error) {
+			if h := hops.Extract(req.Context()); !h.IsZero() {
+				rt.logger.Debug(
+					"injecting hops into request headers",
+					zap.Int("hops", h.Count),
+					zap.Strings("path", h.Path),
+				)
+
+				h.SetHeader(req.Header)
+			}
+
+			return next(req)
+		}
+	}
+}
+
 // WithLogger sets the logger for the GovExtRoundTripper.
 func WithLogger(logger *zap.Logger) Option {
 	return func(rt *GovExtRoundTripper) {
@@ -74,23 +97,86 @@ func WithLogger(logger *zap.Logger) Option {
 }
 
 // WithCorrelationID injects the current correlation ID into the outgoing
-// request headers.
-func WithCorrelationID() Option {
+// request headers. The correlation ID is also added to the baggage and the
+// attributes of the current span, apply it after WithTraceContext for the
+// baggage to be propagated.
+func WithCorrelationID(opts ...CorrelationIDOption) Option {
+	cfg := &correlationIDConfig{resourceID: DefaultResourceID}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	return func(rt *GovExtRoundTripper) {
 		next := rt.roundtripperChain
 		rt.roundtripperChain = func(req *http.Request) (*http.Response, error) {
 			ctx := req.Context()
 
-			if cid := events.ExtractCorrelationID(ctx); cid != "" {
+			cid := events.ExtractCorrelationID(ctx)
+			if cid == "" && cfg.ensurer != nil {
+				var err error
+
+				ctx, cid, err = cfg.ensurer.EnsureCorrelationID(ctx)
+				if err != nil {
+					rt.logger.Warn(
+						"failed to register generated correlation ID",
+						zap.String("correlation_id", cid),
+						zap.Error(err),
+					)
+				} else {
+					rt.logger.Debug("generated correlation ID for request", zap.String("correlation_id", cid))
+				}
+
+				req = req.WithContext(ctx)
+			}
+
+			if cid != "" {
 				rt.logger.Debug(
 					"injecting correlation ID into request headers",
 					zap.String("correlation_id", cid),
 				)
 
 				req.Header.Set(events.GovernorEventCorrelationIDHeader, cid)
+
+				ctx = eventrouter.ContextWithCorrelationID(ctx, cid)
+				req = req.WithContext(ctx)
 			}
 
-			return next(req)
+			if cid == "" || cfg.recorder == nil || !isWrite(req) {
+				return next(req)
+			}
+
+			// the write is recorded before the request is sent, Governor may
+			// publish the event it causes before responding. A failure to
+			// record the write must not fail the request, the resulting event
+			// is processed as if it was not self-originated.
+			resourceID := cfg.resourceID(req)
+			if err := cfg.recorder.RecordWrite(ctx, cid, resourceID); err != nil {
+				rt.logger.Warn(
+					"failed to record write request",
+					zap.String("correlation_id", cid),
+					zap.String("resource_id", resourceID),
+					zap.Error(err),
+				)
+
+				return next(req)
+			}
+
+			resp, err := next(req)
+			if err == nil && isSuccess(resp) {
+				return resp, nil
+			}
+
+			if rerr := cfg.recorder.RemoveWrite(ctx, cid, resourceID); rerr != nil {
+				rt.logger.Warn(
+					"failed to remove write request",
+					zap.String("correlation_id", cid),
+					zap.String("resource_id", resourceID),
+					zap.Error(rerr),
+				)
+			}
+
+			return resp, err
 		}
 	}
 }
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"go.uber.org/zap"
+)
+
+// DefaultAdminPath is the path the admin API is served under
+const DefaultAdminPath = "/admin"
+
+// WithAdminAPI serves the admin API under DefaultAdminPath on the admin
+// listener, with the middleware applied to its routes. The admin API lists the
+// routes of the event router, the events in flight and the last processed
+// events, pauses and resumes the dispatch of events per subject, and queries
+// and flushes the correlation ID history cache.
+//
+// Without an admin listener the admin API is only served by the HTTP listener
+// when authentication is enabled with WithJWTAuth, or when it is allowed
+// without authentication with WithUnauthenticatedAdminAPI.
+func WithAdminAPI(mw ...gin.HandlerFunc) Option {
+	return func(s *Server) {
+		s.adminRouteGroups = append(s.adminRouteGroups, routeGroup{
+			path:        DefaultAdminPath,
+			routes:      s.adminRoutes,
+			middleware:  mw,
+			requireAuth: true,
+		})
+	}
+}
+
+// WithUnauthenticatedAdminAPI allows serving the admin API on the HTTP
+// listener without authentication, e.g. when the middleware of WithAdminAPI
+// authenticates the requests or the listener is not reachable from outside.
+// Anyone who can reach the listener can then pause the dispatch of events and
+// flush the history cache.
+func WithUnauthenticatedAdminAPI() Option {
+	return func(s *Server) {
+		s.unauthenticatedAdminAPI = true
+	}
+}
+
+// WithRecentEventsSize sets the number of processed events kept for the admin
+// API
+func WithRecentEventsSize(n int) Option {
+	return func(s *Server) {
+		s.recentEventsSize = n
+	}
+}
+
+// adminRoutes registers the admin API routes
+func (s *Server) adminRoutes(rg *gin.RouterGroup) {
+	rg.GET("/routes", s.adminListRoutes)
+
+	rg.GET("/events/in-flight", s.adminInFlightEvents)
+	rg.GET("/events/recent", s.adminRecentEvents)
+
+	rg.GET("/subjects/paused", s.adminPausedSubjects)
+	rg.POST("/subjects/:subject/pause", s.adminPauseSubject)
+	rg.POST("/subjects/:subject/resume", s.adminResumeSubject)
+
+	rg.GET("/history-cache/:id", s.adminQueryHistoryCache)
+	rg.DELETE("/history-cache/:id", s.adminRemoveFromHistoryCache)
+	rg.DELETE("/history-cache", s.adminFlushHistoryCache)
+}
+
+// adminListRoutes lists the routes of the event router
+func (s *Server) adminListRoutes(c *gin.Context) {
+	in, ok := s.eventRouter.(eventrouter.Introspector)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"message": "event router does not support introspection"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"routes": in.Routes()})
+}
+
+// adminInFlightEvents lists the events being processed
+func (s *Server) adminInFlightEvents(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"events": s.dispatcher.InFlight()})
+}
+
+// adminRecentEvents lists the last processed events
+func (s *Server) adminRecentEvents(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"events": s.dispatcher.Recent()})
+}
+
+// adminPausedSubjects lists the paused subjects, and the number of events held
+// per subject
+func (s *Server) adminPausedSubjects(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"subjects": s.dispatcher.Paused(), "held": s.dispatcher.Held()})
+}
+
+// adminPauseSubject pauses the dispatch of the events on a subject
+func (s *Server) adminPauseSubject(c *gin.Context) {
+	subj := c.Param("subject")
+
+	if s.dispatcher.Pause(subj) {
+		s.logger.Warn("paused event dispatch", zap.String("subject", subj))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subject": subj, "paused": true})
+}
+
+// adminResumeSubject resumes the dispatch of the events on a subject
+func (s *Server) adminResumeSubject(c *gin.Context) {
+	subj := c.Param("subject")
+
+	if s.resumeSubject(subj) {
+		s.logger.Info("resumed event dispatch", zap.String("subject", subj))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subject": subj, "paused": false})
+}
+
+// adminQueryHistoryCache reports whether an ID is in the history cache
+func (s *Server) adminQueryHistoryCache(c *gin.Context) {
+	ks, ok := s.histcache.(historycache.KeyStore)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"message": "history cache does not support queries"})
+		return
+	}
+
+	id := c.Param("id")
+
+	exists, err := ks.Exists(c.Request.Context(), id)
+	if err != nil {
+		s.adminError(c, "failed to query history cache", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "exists": exists})
+}
+
+// adminRemoveFromHistoryCache removes an ID from the history cache
+func (s *Server) adminRemoveFromHistoryCache(c *gin.Context) {
+	if s.histcache == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"message": "history cache is not configured"})
+		return
+	}
+
+	id := c.Param("id")
+
+	if err := s.histcache.Remove(c.Request.Context(), id); err != nil {
+		s.adminError(c, "failed to remove from history cache", err)
+		return
+	}
+
+	s.logger.Info("removed ID from history cache", zap.String("id", id))
+
+	c.Status(http.StatusNoContent)
+}
+
+// adminFlushHistoryCache removes all the IDs from the history cache
+func (s *Server) adminFlushHistoryCache(c *gin.Context) {
+	p, ok := s.histcache.(historycache.Purger)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"message": "history cache does not support flushing"})
+		return
+	}
+
+	if err := p.Purge(c.Request.Context()); err != nil {
+		if errors.Is(err, historycache.ErrNotPurger) {
+			c.JSON(http.StatusNotImplemented, gin.H{"message": "history cache does not support flushing"})
+			return
+		}
+
+		s.adminError(c, "failed to flush history cache", err)
+
+		return
+	}
+
+	s.logger.Warn("flushed history cache")
+
+	c.Status(http.StatusNoContent)
+}
+
+// adminError logs the error and responds with an internal server error
+func (s *Server) adminError(c *gin.Context, msg string, err error) {
+	s.logger.Error(msg, zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"message": msg, "error": err.Error()})
+}
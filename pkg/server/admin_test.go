@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEventClient struct {
+	msgs chan *EventMessage
+}
+
+func (c *testEventClient) Subscribe(context.Context, string) error { return nil }
+func (c *testEventClient) Messages() <-chan *EventMessage          { return c.msgs }
+func (c *testEventClient) Shutdown() error                         { return nil }
+
+func adminRequest(t *testing.T, h http.Handler, method, path string, out any) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.TODO(), method, path, nil)
+	h.ServeHTTP(w, req)
+
+	if out != nil {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+	}
+
+	return w.Code
+}
+
+func TestDispatcherRecent(t *testing.T) {
+	d := newDispatcher(2)
+
+	for _, action := range []string{"a", "b", "c"} {
+		d.start("subj", &govevents.Event{Action: action})(nil)
+	}
+
+	d.start("subj", &govevents.Event{Action: "d"})(eventrouter.ErrHandlerTimeout)
+
+	recent := d.Recent()
+	require.Len(t, recent, 2)
+	assert.Equal(t, "d", recent[0].Action)
+	assert.Equal(t, OutcomeTimeout, recent[0].Outcome)
+	assert.Equal(t, "c", recent[1].Action)
+	assert.Equal(t, OutcomeSuccess, recent[1].Outcome)
+	assert.Empty(t, d.InFlight())
+}
+
+func TestAdminPauseResume(t *testing.T) {
+	ec := &testEventClient{msgs: make(chan *EventMessage)}
+	router := eventrouter.NewRouter()
+	processed := make(chan string, 1)
+	release := make(chan struct{})
+
+	router.Create("groups", func(_ context.Context, e *govevents.Event) error {
+		<-release
+		processed <- e.ExtensionResourceID
+
+		return errors.New("boom") //nolint: err113
+	})
+
+	hs := NewServer("", "", "", WithEventRouter(router), WithAdminAPI(), WithUnauthenticatedAdminAPI())
+	hs.eventClient = ec
+	h := hs.newHTTPServer().Handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hs.ListenEvents(ctx)
+
+	assert.Equal(t, http.StatusOK, adminRequest(t, h, http.MethodPost, "/admin/subjects/groups/pause", nil))
+
+	var paused struct {
+		Subjects []string
+		Held     map[string]int
+	}
+
+	adminRequest(t, h, http.MethodGet, "/admin/subjects/paused", &paused)
+	assert.Equal(t, []string{"groups"}, paused.Subjects)
+	assert.Equal(t, map[string]int{"groups": 0}, paused.Held)
+
+	ec.msgs <- &EventMessage{Subject: "groups", Event: &govevents.Event{
+		Action:              govevents.GovernorEventCreate,
+		ExtensionResourceID: "r1",
+	}}
+
+	var inflight struct{ Events []InFlightEvent }
+
+	// the event is held while the subject is paused
+	require.Eventually(t, func() bool {
+		adminRequest(t, h, http.MethodGet, "/admin/subjects/paused", &paused)
+		return paused.Held["groups"] == 1
+	}, time.Second, time.Millisecond)
+
+	adminRequest(t, h, http.MethodGet, "/admin/events/in-flight", &inflight)
+	assert.Empty(t, inflight.Events)
+
+	assert.Equal(t, http.StatusOK, adminRequest(t, h, http.MethodPost, "/admin/subjects/groups/resume", nil))
+
+	require.Eventually(t, func() bool {
+		adminRequest(t, h, http.MethodGet, "/admin/events/in-flight", &inflight)
+		return len(inflight.Events) == 1
+	}, time.Second, 10*time.Millisecond) //nolint: mnd
+
+	assert.Equal(t, "r1", inflight.Events[0].ResourceID)
+
+	close(release)
+	assert.Equal(t, "r1", <-processed)
+
+	var recent struct{ Events []ProcessedEvent }
+
+	require.Eventually(t, func() bool {
+		adminRequest(t, h, http.MethodGet, "/admin/events/recent", &recent)
+		return len(recent.Events) == 1
+	}, time.Second, 10*time.Millisecond) //nolint: mnd
+
+	assert.Equal(t, OutcomeError, recent.Events[0].Outcome)
+	assert.Equal(t, "boom", recent.Events[0].Error)
+
+	var routes struct{ Routes []eventrouter.RouteInfo }
+	adminRequest(t, h, http.MethodGet, "/admin/routes", &routes)
+	require.Len(t, routes.Routes, 1)
+	assert.Equal(t, "groups", routes.Routes[0].Subject)
+}
+
+func TestHeldEventsOrder(t *testing.T) {
+	ec := &testEventClient{msgs: make(chan *EventMessage)}
+	router := eventrouter.NewRouter()
+	processed := make(chan string, 4) //nolint: mnd
+
+	router.Create("groups", func(_ context.Context, e *govevents.Event) error {
+		processed <- e.ExtensionResourceID
+		return nil
+	})
+
+	hs := NewServer("", "", "", WithEventRouter(router), WithMaxWorkers(1))
+	hs.eventClient = ec
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hs.ListenEvents(ctx)
+
+	send := func(id string) {
+		ec.msgs <- &EventMessage{Subject: "groups", Event: &govevents.Event{
+			Action:              govevents.GovernorEventCreate,
+			ExtensionResourceID: id,
+		}}
+	}
+
+	hs.dispatcher.Pause("groups")
+
+	for _, id := range []string{"r1", "r2", "r3"} {
+		send(id)
+	}
+
+	require.Eventually(t, func() bool {
+		return hs.dispatcher.Held()["groups"] == 3
+	}, time.Second, time.Millisecond)
+
+	// no goroutine or worker is used by the held events
+	assert.Empty(t, hs.sam)
+
+	require.True(t, hs.resumeSubject("groups"))
+
+	// events received while the held events are dispatched are queued after
+	// them
+	send("r4")
+
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		assert.Equal(t, id, <-processed)
+	}
+
+	require.Eventually(t, func() bool {
+		return len(hs.dispatcher.Held()) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestAdminHistoryCache(t *testing.T) {
+	hs := NewServer("", "", "", WithAdminAPI(), WithUnauthenticatedAdminAPI())
+	h := hs.newHTTPServer().Handler
+
+	_, err := hs.histcache.ExistsOrStore(context.Background(), "cid")
+	require.NoError(t, err)
+
+	var query struct {
+		ID     string
+		Exists bool
+	}
+
+	adminRequest(t, h, http.MethodGet, "/admin/history-cache/cid", &query)
+	assert.True(t, query.Exists)
+
+	assert.Equal(t, http.StatusNoContent, adminRequest(t, h, http.MethodDelete, "/admin/history-cache/cid", nil))
+
+	adminRequest(t, h, http.MethodGet, "/admin/history-cache/cid", &query)
+	assert.False(t, query.Exists)
+
+	_, err = hs.histcache.ExistsOrStore(context.Background(), "other")
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusNoContent, adminRequest(t, h, http.MethodDelete, "/admin/history-cache", nil))
+
+	adminRequest(t, h, http.MethodGet, "/admin/history-cache/other", &query)
+	assert.False(t, query.Exists)
+}
+
+func TestAdminAPIRequiresAuth(t *testing.T) {
+	jwksURL, _ := newTestJWKS(t)
+
+	auth, err := NewJWTAuth(context.Background(), JWTAuthConfig{
+		Issuer:    testIssuer,
+		JWKSURL:   jwksURL,
+		Audiences: []string{"governor-extension"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		opts  []Option
+		admin bool
+		code  int
+	}{
+		{"without auth", nil, false, http.StatusNotFound},
+		{"unauthenticated allowed", []Option{WithUnauthenticatedAdminAPI()}, false, http.StatusOK},
+		{"with auth", []Option{WithJWTAuth(auth)}, false, http.StatusUnauthorized},
+		{"admin listener without auth", []Option{WithAdminListen("127.0.0.1:0")}, true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hs := NewServer("", "", "", append(tt.opts, WithAdminAPI())...)
+
+			h := hs.newHTTPServer().Handler
+			if tt.admin {
+				h = hs.newAdminHTTPServer().Handler
+			}
+
+			assert.Equal(t, tt.code, adminRequest(t, h, http.MethodPost, "/admin/subjects/groups/pause", nil))
+			assert.Equal(t, tt.code == http.StatusOK, len(hs.dispatcher.Paused()) == 1)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// contextKeyTokenSubject is the gin context key of the subject of the
+// validated bearer token
+const contextKeyTokenSubject = "jwt.subject"
+
+// JWTAuthConfig holds the configuration of the JWT bearer token validation
+type JWTAuthConfig struct {
+	// Issuer is the issuer of the tokens, it is used for OIDC discovery when
+	// JWKSURL is empty
+	Issuer string
+	// JWKSURL is the URL of the JSON Web Key Set used to verify the tokens
+	JWKSURL string
+	// Audiences are the accepted audiences, a token must have at least one of
+	// them, no check is done when empty
+	Audiences []string
+	// Scopes are the scopes required on every token
+	Scopes []string
+}
+
+// JWTAuth validates JWT bearer tokens, e.g. the access tokens issued for the
+// Governor API, with the keys of the issuer
+type JWTAuth struct {
+	verifier  *oidc.IDTokenVerifier
+	audiences []string
+	scopes    []string
+	logger    *zap.Logger
+}
+
+// JWTAuthOpt is a function that configures a JWTAuth
+type JWTAuthOpt func(*JWTAuth)
+
+// JWTAuthWithLogger sets the logger of a JWTAuth
+func JWTAuthWithLogger(l *zap.Logger) JWTAuthOpt {
+	return func(a *JWTAuth) {
+		a.logger = l
+	}
+}
+
+// NewJWTAuth creates a JWTAuth from the configuration. The keys are fetched
+// from cfg.JWKSURL, or from the JWKS URL of the issuer's OIDC discovery
+// document when it is empty.
+func NewJWTAuth(ctx context.Context, cfg JWTAuthConfig, opts ...JWTAuthOpt) (*JWTAuth, error) {
+	if cfg.Issuer == "" {
+		return nil, ErrMissingAuthIssuer
+	}
+
+	// audiences are checked by the middleware, go-oidc only supports one
+	oidccfg := &oidc.Config{SkipClientIDCheck: true}
+
+	var verifier *oidc.IDTokenVerifier
+
+	if cfg.JWKSURL != "" {
+		verifier = oidc.NewVerifier(cfg.Issuer, oidc.NewRemoteKeySet(ctx, cfg.JWKSURL), oidccfg)
+	} else {
+		provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrOIDCDiscovery, err)
+		}
+
+		verifier = provider.Verifier(oidccfg)
+	}
+
+	a := &JWTAuth{
+		verifier:  verifier,
+		audiences: cfg.Audiences,
+		scopes:    cfg.Scopes,
+		logger:    zap.NewNop(),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	a.logger = a.logger.With(zap.String("component", "jwt-auth"))
+
+	return a, nil
+}
+
+// tokenClaims are the claims checked in addition to the standard ones, scopes
+// are either a space separated scope claim or a scp array claim
+type tokenClaims struct {
+	Scope string   `json:"scope"`
+	Scp   []string `json:"scp"`
+}
+
+func (tc tokenClaims) scopes() []string {
+	return append(strings.Fields(tc.Scope), tc.Scp...)
+}
+
+// Middleware returns a gin middleware rejecting the requests without a valid
+// bearer token, with the configured audiences and scopes plus the given ones.
+func (a *JWTAuth) Middleware(scopes ...string) gin.HandlerFunc {
+	required := append(slices.Clip(a.scopes), scopes...)
+
+	return func(c *gin.Context) {
+		raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || raw == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "missing bearer token"})
+			return
+		}
+
+		token, err := a.verifier.Verify(c.Request.Context(), raw)
+		if err != nil {
+			a.logger.Debug("invalid bearer token", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid bearer token"})
+
+			return
+		}
+
+		if len(a.audiences) > 0 && !slices.ContainsFunc(token.Audience, func(aud string) bool {
+			return slices.Contains(a.audiences, aud)
+		}) {
+			a.logger.Debug("invalid token audience", zap.Strings("audience", token.Audience))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid token audience"})
+
+			return
+		}
+
+		var claims tokenClaims
+		if err := token.Claims(&claims); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid token claims"})
+			return
+		}
+
+		granted := claims.scopes()
+		for _, scope := range required {
+			if !slices.Contains(granted, scope) {
+				a.logger.Debug(
+					"missing token scope",
+					zap.String("subject", token.Subject),
+					zap.String("scope", scope),
+				)
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "missing scope " + scope})
+
+				return
+			}
+		}
+
+		c.Set(contextKeyTokenSubject, token.Subject)
+		c.Next()
+	}
+}
+
+// TokenSubject returns the subject of the bearer token validated by JWTAuth,
+// or an empty string if the request was not authenticated
+func TokenSubject(c *gin.Context) string {
+	return c.GetString(contextKeyTokenSubject)
+}
+
+// WithJWTAuth requires a valid bearer token on the routes registered with
+// WithRoutes and WithAdminRoutes
+func WithJWTAuth(a *JWTAuth) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+// WithAdminScopes sets the scopes required on the admin routes, in addition
+// to the scopes of the JWTAuth
+func WithAdminScopes(scopes ...string) Option {
+	return func(s *Server) {
+		s.adminScopes = scopes
+	}
+}
+
+// WithProtectedHealth requires a valid bearer token on the health endpoints,
+// they are public by default
+func WithProtectedHealth() Option {
+	return func(s *Server) {
+		s.protectHealth = true
+	}
+}
+
+// WithProtectedMetrics requires a valid bearer token on the metrics endpoint,
+// it is public by default
+func WithProtectedMetrics() Option {
+	return func(s *Server) {
+		s.protectMetrics = true
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIssuer = "https://issuer.example.com/"
+
+type testClaims struct {
+	jwt.Claims
+	Scope string `json:"scope,omitempty"`
+}
+
+// newTestJWKS serves the public key of a new signing key as a JWKS and
+// returns a function signing tokens with it
+func newTestJWKS(t *testing.T) (string, func(claims testClaims) string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048) //nolint: mnd
+	require.NoError(t, err)
+
+	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key: key.Public(), KeyID: "test", Algorithm: string(jose.RS256), Use: "sig",
+	}}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(srv.Close)
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"),
+	)
+	require.NoError(t, err)
+
+	return srv.URL, func(claims testClaims) string {
+		raw, err := jwt.Signed(signer).Claims(claims).Serialize()
+		require.NoError(t, err)
+
+		return raw
+	}
+}
+
+func validClaims(scope string) testClaims {
+	return testClaims{
+		Claims: jwt.Claims{
+			Issuer:   testIssuer,
+			Subject:  "client-id",
+			Audience: jwt.Audience{"governor-extension"},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Scope: scope,
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	jwksURL, sign := newTestJWKS(t)
+
+	auth, err := NewJWTAuth(context.Background(), JWTAuthConfig{
+		Issuer:    testIssuer,
+		JWKSURL:   jwksURL,
+		Audiences: []string{"governor-extension"},
+		Scopes:    []string{"read:governor"},
+	})
+	require.NoError(t, err)
+
+	hs := NewServer(
+		"", "", "",
+		WithJWTAuth(auth),
+		WithAdminScopes("admin:governor"),
+		WithRoutes("/api", func(rg *gin.RouterGroup) {
+			rg.GET("/whoami", func(c *gin.Context) { c.String(http.StatusOK, TokenSubject(c)) })
+		}),
+		WithAdminRoutes("/admin", func(rg *gin.RouterGroup) {
+			rg.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+		}),
+	)
+	h := hs.newHTTPServer().Handler
+
+	expired := validClaims("read:governor")
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	otherAudience := validClaims("read:governor")
+	otherAudience.Audience = jwt.Audience{"other"}
+
+	otherIssuer := validClaims("read:governor")
+	otherIssuer.Issuer = "https://other.example.com/"
+
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		code  int
+	}{
+		{"missing token", "/api/whoami", "", http.StatusUnauthorized},
+		{"malformed token", "/api/whoami", "not-a-jwt", http.StatusUnauthorized},
+		{"expired token", "/api/whoami", sign(expired), http.StatusUnauthorized},
+		{"wrong audience", "/api/whoami", sign(otherAudience), http.StatusUnauthorized},
+		{"wrong issuer", "/api/whoami", sign(otherIssuer), http.StatusUnauthorized},
+		{"missing scope", "/api/whoami", sign(validClaims("write:governor")), http.StatusForbidden},
+		{"valid token", "/api/whoami", sign(validClaims("read:governor")), http.StatusOK},
+		{"missing admin scope", "/admin/ping", sign(validClaims("read:governor")), http.StatusForbidden},
+		{"valid admin token", "/admin/ping", sign(validClaims("read:governor admin:governor")), http.StatusOK},
+		{"public health", "/healthz", "", http.StatusOK},
+		{"public metrics", "/metrics", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequestWithContext(context.TODO(), http.MethodGet, tt.path, nil)
+
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestJWTAuthProtectedHealth(t *testing.T) {
+	jwksURL, sign := newTestJWKS(t)
+
+	auth, err := NewJWTAuth(context.Background(), JWTAuthConfig{Issuer: testIssuer, JWKSURL: jwksURL})
+	require.NoError(t, err)
+
+	hs := NewServer("", "", "", WithJWTAuth(auth), WithProtectedHealth(), WithProtectedMetrics())
+	h := hs.newHTTPServer().Handler
+
+	for _, path := range []string{"/healthz", "/healthz/readiness", "/metrics"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(context.TODO(), http.MethodGet, path, nil)
+		h.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+
+		w = httptest.NewRecorder()
+		req.Header.Set("Authorization", "Bearer "+sign(validClaims("")))
+		h.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
+
+func TestNewJWTAuthMissingIssuer(t *testing.T) {
+	_, err := NewJWTAuth(context.Background(), JWTAuthConfig{})
+	assert.ErrorIs(t, err, ErrMissingAuthIssuer)
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/configs"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+)
+
+const tracerName = "github.com/metal-toolbox/governor-extension-sdk/pkg/server"
+
+// NewServerFromConfig creates a new HTTP server from configs.AppConfig using
+// the NATS connection. When a NATS bucket is configured, the bucket is created
+// or bound and used as the history cache of the correlation ID processor. When
+// a history file is configured instead, the file is opened and used as the
+// history cache, and closed once the server is shut down. Otherwise an
+// in-memory cache is used. The configured skip rules replace the default update
+// only skip strategy, and the configured filter drops the events not matching
+// it. When an auth issuer is configured, the
+// custom and admin routes require a JWT bearer token. The options are applied
+// after the configuration, so they take precedence.
+func NewServerFromConfig(nc *nats.Conn, opts ...Option) (*Server, error) {
+	cfg := configs.AppConfig
+	tracer := otel.GetTracerProvider().Tracer(tracerName)
+
+	natsClient, err := NewNATSClient(
+		WithNATSConn(nc),
+		WithNATSQueueGroup(cfg.NATS.QueueGroup, cfg.NATS.QueueSize),
+		WithNATSTracer(tracer),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	skipRules, err := SkipRulesFromConfig(cfg.EventRouter)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := EventFilterFromConfig(cfg.EventRouter, eventrouter.EventFilterWithName("config"))
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := []Option{
+		WithNATSClient(natsClient),
+		WithTracer(tracer),
+		WithDebug(cfg.Logging.Debug),
+		WithSelfActorIDs(cfg.Governor.SelfActorIDs()...),
+		WithAdminListen(cfg.Server.AdminListen),
+	}
+
+	if len(skipRules) > 0 {
+		defaults = append(defaults, WithSkipRules(skipRules...))
+	}
+
+	if filter != nil {
+		defaults = append(defaults, WithEventFilter(filter))
+	}
+
+	defaults = append(defaults, WithCORS(cfg.Server.CORS.Config()))
+
+	if cfg.Server.TLS.Enabled() {
+		if err := cfg.Server.TLS.Validate(); err != nil {
+			return nil, err
+		}
+
+		minVersion, _ := cfg.Server.TLS.MinTLSVersion()
+
+		defaults = append(defaults, WithTLS(TLSConfig{
+			CertFile:     cfg.Server.TLS.CertFile,
+			KeyFile:      cfg.Server.TLS.KeyFile,
+			ClientCAFile: cfg.Server.TLS.ClientCAFile,
+			MinVersion:   minVersion,
+		}))
+	}
+
+	if auth := cfg.Server.Auth; auth.Issuer != "" {
+		// the context is used to fetch the keys for the lifetime of the server
+		a, err := NewJWTAuth(context.Background(), JWTAuthConfig{
+			Issuer:    auth.Issuer,
+			JWKSURL:   auth.JWKSURL,
+			Audiences: auth.Audiences,
+			Scopes:    auth.Scopes,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		defaults = append(defaults, WithJWTAuth(a), WithAdminScopes(auth.AdminScopes...))
+
+		if auth.ProtectHealth {
+			defaults = append(defaults, WithProtectedHealth())
+		}
+
+		if auth.ProtectMetrics {
+			defaults = append(defaults, WithProtectedMetrics())
+		}
+	}
+
+	if cfg.NATS.Bucket.Name != "" && cfg.EventRouter.HistoryFile.Path != "" {
+		return nil, configs.ErrMultipleHistoryCaches
+	}
+
+	if cfg.NATS.Bucket.Name != "" {
+		kvcfg, err := cfg.NATS.Bucket.KeyValueConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		js, err := nc.JetStream()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+		}
+
+		hc, err := historycache.NewNATSCacheFromConfig(js, kvcfg, historycache.WithTracer(tracer))
+		if err != nil {
+			return nil, err
+		}
+
+		defaults = append(defaults, WithCorrelationIDHistoryCache(hc))
+	}
+
+	// the file is opened last, so it is not left open when the configuration
+	// is invalid
+	if hf := cfg.EventRouter.HistoryFile; hf.Path != "" {
+		fc, err := historycache.NewFileCache(hf.Path,
+			historycache.WithName("correlation-id"),
+			historycache.WithTTL(hf.TTL),
+			historycache.WithSweepInterval(hf.SweepInterval),
+			historycache.WithCompactInterval(hf.CompactInterval),
+			historycache.WithTracer(tracer),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		defaults = append(defaults, WithCorrelationIDHistoryCache(fc), withCloser(fc))
+	}
+
+	return NewServer(
+		cfg.Server.Listen,
+		cfg.Governor.ExtensionID,
+		cfg.Governor.ERDsPath,
+		append(defaults, opts...)...,
+	), nil
+}
+
+// EventFilterFromConfig creates an event filter from the configured filter
+// expression, it returns nil if no filter is configured
+func EventFilterFromConfig(cfg configs.EventRouter, opts ...eventrouter.EventFilterOpt) (*eventrouter.EventFilter, error) {
+	if cfg.Filter == "" {
+		return nil, nil
+	}
+
+	return eventrouter.NewEventFilterFromExpression(cfg.Filter, opts...)
+}
+
+// SkipRulesFromConfig creates the correlation ID skip rules from the
+// configured skip rules, unnamed rules are named after their position
+func SkipRulesFromConfig(cfg configs.EventRouter) ([]eventrouter.SkipRule, error) {
+	rules := make([]eventrouter.SkipRule, 0, len(cfg.SkipRules))
+
+	for i, sr := range cfg.SkipRules {
+		rule := eventrouter.SkipRule{
+			Name:     sr.Name,
+			Actions:  sr.Actions,
+			Subjects: sr.Subjects,
+			ERDs:     sr.ERDs,
+			Never:    sr.Never,
+		}
+
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+
+		if sr.Expression != "" {
+			pred, err := eventrouter.ParsePredicate(sr.Expression)
+			if err != nil {
+				return nil, fmt.Errorf("skip rule %s: %w", rule.Name, err)
+			}
+
+			rule.Predicate = pred
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
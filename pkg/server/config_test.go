@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/configs"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setAppConfig replaces configs.AppConfig for the duration of the test
+func setAppConfig(t *testing.T, fn func()) {
+	t.Helper()
+
+	saved := configs.AppConfig
+
+	t.Cleanup(func() { configs.AppConfig = saved })
+
+	fn()
+}
+
+func TestNewServerFromConfigHistoryFile(t *testing.T) {
+	setAppConfig(t, func() {
+		configs.AppConfig.EventRouter.HistoryFile = configs.HistoryFile{
+			Path: filepath.Join(t.TempDir(), "history.db"),
+		}
+	})
+
+	hs, err := NewServerFromConfig(nil)
+	require.NoError(t, err)
+
+	fc, ok := hs.histcache.(*historycache.FileCache)
+	require.True(t, ok)
+
+	// the file is closed with the server
+	require.Len(t, hs.closers, 1)
+	assert.Same(t, fc, hs.closers[0])
+	require.NoError(t, fc.Close())
+}
+
+func TestNewServerFromConfigMultipleHistoryCaches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	setAppConfig(t, func() {
+		configs.AppConfig.NATS.Bucket.Name = "history"
+		configs.AppConfig.EventRouter.HistoryFile = configs.HistoryFile{Path: path}
+	})
+
+	_, err := NewServerFromConfig(nil)
+	require.ErrorIs(t, err, configs.ErrMultipleHistoryCaches)
+
+	assert.NoFileExists(t, path)
+}
+
+func TestSkipRulesFromConfig(t *testing.T) {
+	rules, err := SkipRulesFromConfig(configs.EventRouter{
+		SkipRules: []configs.SkipRule{
+			{Name: "never-deletes", Actions: []string{govevents.GovernorEventDelete}, Never: true},
+			{Name: "group-updates", Actions: []string{govevents.GovernorEventUpdate}, Subjects: []string{"groups.*"}},
+			{Expression: `action == "APPROVE" && group_id in ("group-a", "group-b")`},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+
+	// unnamed rules are named after their position
+	assert.Equal(t, "rule-2", rules[2].Name)
+
+	cidp := eventrouter.NewCorrelationIDProcessor(eventrouter.CorrelationIDProcessorWithSkipRules(rules...))
+
+	tests := []struct {
+		name   string
+		subj   string
+		action string
+		group  string
+		skip   bool
+		rule   string
+	}{
+		{"never rule", "groups.members", govevents.GovernorEventDelete, "", false, "never-deletes"},
+		{"subject rule", "groups.members", govevents.GovernorEventUpdate, "", true, "group-updates"},
+		{"subject mismatch", "users", govevents.GovernorEventUpdate, "", false, ""},
+		{"expression rule", "approvals", govevents.GovernorEventApprove, "group-b", true, "rule-2"},
+		{"expression mismatch", "approvals", govevents.GovernorEventApprove, "group-c", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &govevents.Event{
+				Action:  tt.action,
+				GroupID: tt.group,
+				Headers: map[string][]string{
+					govevents.GovernorEventCorrelationIDHeader: {"cid-" + tt.name},
+				},
+			}
+
+			// the correlation ID is seen for the first time, nothing is skipped
+			skip, rule, err := cidp.ShouldSkipEvent(context.Background(), tt.subj, event)
+			require.NoError(t, err)
+			assert.False(t, skip)
+			assert.Empty(t, rule)
+
+			skip, rule, err = cidp.ShouldSkipEvent(context.Background(), tt.subj, event)
+			require.NoError(t, err)
+			assert.Equal(t, tt.skip, skip)
+			assert.Equal(t, tt.rule, rule)
+		})
+	}
+}
+
+func TestSkipRulesFromConfigInvalidExpression(t *testing.T) {
+	_, err := SkipRulesFromConfig(configs.EventRouter{
+		SkipRules: []configs.SkipRule{{Name: "invalid", Expression: `action ==`}},
+	})
+	require.ErrorIs(t, err, eventrouter.ErrInvalidPredicate)
+	assert.ErrorContains(t, err, "skip rule invalid")
+}
+
+func TestEventFilterFromConfig(t *testing.T) {
+	f, err := EventFilterFromConfig(configs.EventRouter{})
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	_, err = EventFilterFromConfig(configs.EventRouter{Filter: `action ==`})
+	require.ErrorIs(t, err, eventrouter.ErrInvalidPredicate)
+}
+
+func TestNewServerFromConfigEventRouter(t *testing.T) {
+	setAppConfig(t, func() {
+		configs.AppConfig.EventRouter.Filter = `group_id != "ignored"`
+		configs.AppConfig.EventRouter.SkipRules = []configs.SkipRule{
+			{Name: "skip-creates", Actions: []string{govevents.GovernorEventCreate}},
+		}
+	})
+
+	hs, err := NewServerFromConfig(nil)
+	require.NoError(t, err)
+
+	var calls int
+
+	hs.eventRouter.Create("groups", func(context.Context, *govevents.Event) error {
+		calls++
+		return nil
+	})
+
+	process := func(group string) {
+		t.Helper()
+
+		require.NoError(t, hs.eventRouter.Process(context.Background(), "groups", &govevents.Event{
+			Action:  govevents.GovernorEventCreate,
+			GroupID: group,
+			Headers: map[string][]string{
+				govevents.GovernorEventCorrelationIDHeader: {"cid-" + group},
+			},
+		}))
+	}
+
+	// filtered events are not processed
+	process("ignored")
+	assert.Zero(t, calls)
+
+	// the configured rule skips creates instead of the default updates only
+	process("group-a")
+	process("group-a")
+	assert.Equal(t, 1, calls)
+}
+
+func TestDefaultCORSWithoutCredentials(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"server default", nil},
+		{"config default", []Option{WithCORS(configs.ServerCORS{}.Config())}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewServer("", "", "", tt.opts...).newHTTPServer().Handler
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequestWithContext(context.Background(), http.MethodGet, "/healthz/liveness", nil)
+			req.Header.Set("Origin", "https://ui.example.com")
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// deferredDispatcher dispatches the events deferred by the event router
+// middlewares, e.g. the eventrouter.Coalescer, on the workers of the server.
+// Deferred events wait for paused subjects, are tracked by the admin API and
+// get their own span, linked to the span they were received with.
+type deferredDispatcher struct {
+	server *Server
+
+	// ctx is cancelled on shutdown, so deferred events stop waiting for
+	// paused subjects
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	shutdown []func()
+}
+
+var _ eventrouter.Dispatcher = eventDispatcher{}
+
+func newDeferredDispatcher(s *Server) *deferredDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &deferredDispatcher{server: s, ctx: ctx, cancel: cancel}
+}
+
+// Dispatch processes the deferred event with the handler on a worker
+func (d *deferredDispatcher) Dispatch(ctx context.Context, event *govevents.Event, handler eventrouter.Handler) error {
+	s := d.server
+	subj := eventrouter.GetSubjectFromContext(ctx)
+
+	// on shutdown the held events are dispatched even if their subject is
+	// paused
+	s.dispatcher.wait(d.ctx, subj)
+
+	s.sam <- struct{}{}
+
+	w := &worker{sam: s.sam}
+	defer w.finish()
+
+	ctx = eventrouter.SaveDispatcherToContext(ctx, eventDispatcher{d, w})
+
+	ctx, span := s.tracer.Start(
+		ctx,
+		"process-deferred-event",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(
+			attribute.String("event.subject", subj),
+			attribute.String("event.action", event.Action),
+			attribute.String("event.resource-id", event.ExtensionResourceID),
+		),
+	)
+	defer span.End()
+
+	done := s.dispatcher.start(subj, event)
+
+	err := handler(ctx, event)
+
+	done(err)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		if errors.Is(err, eventrouter.ErrHandlerTimeout) {
+			s.logger.Warn("timed out processing deferred event", zap.Error(err), zap.String("subject", subj))
+		} else {
+			s.logger.Error("error processing deferred event", zap.Error(err), zap.String("subject", subj))
+		}
+	}
+
+	return err
+}
+
+// eventDispatcher is the deferredDispatcher saved in the context of an event,
+// it lends the worker of the event
+type eventDispatcher struct {
+	*deferredDispatcher
+
+	worker *worker
+}
+
+// ReleaseWorker gives the worker of the event back to the server
+func (d eventDispatcher) ReleaseWorker() func() {
+	return d.worker.release()
+}
+
+// worker is the worker acquired for an event, which the event can give back
+// while it waits. A handler abandoned by its timeout can still hold the
+// released worker once the event returned, so the worker is only given back
+// once: when the event returns the worker is given back unless it is
+// released, and a worker reacquired after that is given back right away.
+type worker struct {
+	sam chan struct{}
+
+	mu       sync.Mutex
+	released bool
+	finished bool
+}
+
+// release gives the worker back and returns a function taking it again,
+// which can be called more than once
+func (w *worker) release() func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.released || w.finished {
+		return func() {}
+	}
+
+	<-w.sam
+	w.released = true
+
+	return sync.OnceFunc(w.reacquire)
+}
+
+// reacquire takes the released worker again, unless the event returned
+func (w *worker) reacquire() {
+	w.mu.Lock()
+	finished := w.finished
+	w.mu.Unlock()
+
+	if finished {
+		return
+	}
+
+	// the lock is not held while waiting for a worker, so the event can
+	// return meanwhile
+	w.sam <- struct{}{}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.finished {
+		<-w.sam
+		return
+	}
+
+	w.released = false
+}
+
+// finish gives the worker back when the event returns, unless it is released
+func (w *worker) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.finished = true
+
+	if !w.released {
+		<-w.sam
+	}
+}
+
+// OnShutdown registers a function called when the server shuts down
+func (d *deferredDispatcher) OnShutdown(fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.shutdown = append(d.shutdown, fn)
+}
+
+// Shutdown runs the shutdown functions, e.g. flushing the events held by
+// coalescers, once the server stopped receiving events
+func (d *deferredDispatcher) Shutdown() {
+	d.cancel()
+
+	d.mu.Lock()
+	fns := d.shutdown
+	d.shutdown = nil
+	d.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
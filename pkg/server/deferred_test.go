@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeferredDispatch(t *testing.T) {
+	c := eventrouter.NewCoalescer(
+		eventrouter.CoalescerWithWindow(time.Millisecond),
+		eventrouter.CoalescerWithMaxAttempts(1),
+	)
+
+	router := eventrouter.NewRouter()
+	router.Update("groups", func(context.Context, *govevents.Event) error {
+		return errors.New("boom") //nolint: err113
+	}, c.MWCoalesce)
+
+	hs := NewServer("", "", "", WithEventRouter(router))
+
+	hs.sam <- struct{}{}
+	hs.processEvent(context.Background(), &EventMessage{Subject: "groups", Event: &govevents.Event{
+		Action:              govevents.GovernorEventUpdate,
+		ExtensionResourceID: "r1",
+	}})
+
+	// the coalesced event is dispatched on a worker and its outcome recorded
+	require.Eventually(t, func() bool {
+		return len(hs.dispatcher.Recent()) == 2 //nolint: mnd
+	}, time.Second, time.Millisecond)
+
+	recent := hs.dispatcher.Recent()
+	assert.Equal(t, OutcomeError, recent[0].Outcome)
+	assert.Equal(t, "boom", recent[0].Error)
+}
+
+func TestDeferredDispatchShutdown(t *testing.T) {
+	processed := make(chan string, 1)
+	c := eventrouter.NewCoalescer(eventrouter.CoalescerWithWindow(time.Hour))
+
+	router := eventrouter.NewRouter()
+	router.Update("groups", func(_ context.Context, e *govevents.Event) error {
+		processed <- e.ExtensionResourceID
+		return nil
+	}, c.MWCoalesce)
+
+	hs := NewServer("", "", "", WithEventRouter(router))
+
+	hs.sam <- struct{}{}
+	hs.processEvent(context.Background(), &EventMessage{Subject: "groups", Event: &govevents.Event{
+		Action:              govevents.GovernorEventUpdate,
+		ExtensionResourceID: "r1",
+	}})
+
+	// held events are flushed on shutdown, even on paused subjects
+	hs.dispatcher.Pause("groups")
+	hs.deferred.Shutdown()
+
+	assert.Equal(t, "r1", <-processed)
+}
+
+func TestBatchLargerThanWorkers(t *testing.T) {
+	const size = 5
+
+	batches := make(chan int, 1)
+	b := eventrouter.NewBatcher(func(_ context.Context, events []*govevents.Event) []error {
+		batches <- len(events)
+		return make([]error, len(events))
+	}, eventrouter.BatcherWithMaxSize(size), eventrouter.BatcherWithMaxWait(time.Hour))
+
+	router := eventrouter.NewRouter()
+	router.Update("groups", b.Handle)
+
+	ec := &testEventClient{msgs: make(chan *EventMessage)}
+	hs := NewServer("", "", "", WithEventRouter(router), WithMaxWorkers(2)) //nolint: mnd
+	hs.eventClient = ec
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hs.ListenEvents(ctx)
+
+	for range size {
+		ec.msgs <- &EventMessage{Subject: "groups", Event: &govevents.Event{Action: govevents.GovernorEventUpdate}}
+	}
+
+	select {
+	case n := <-batches:
+		assert.Equal(t, size, n)
+	case <-time.After(time.Second):
+		t.Fatal("batch did not fill")
+	}
+}
+
+func TestBatchTimeoutKeepsWorkers(t *testing.T) {
+	b := eventrouter.NewBatcher(func(_ context.Context, events []*govevents.Event) []error {
+		return make([]error, len(events))
+	}, eventrouter.BatcherWithMaxWait(time.Hour))
+
+	var running, maxRunning atomic.Int32
+
+	release := make(chan struct{})
+
+	router := eventrouter.NewRouter(eventrouter.WithDefaultTimeout(50 * time.Millisecond)) //nolint: mnd
+	router.Update("groups", b.Handle)
+	router.Update("users", func(context.Context, *govevents.Event) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		<-release
+
+		return nil
+	}, eventrouter.Timeout(time.Hour))
+
+	ec := &testEventClient{msgs: make(chan *EventMessage)}
+	hs := NewServer("", "", "", WithEventRouter(router), WithMaxWorkers(1))
+	hs.eventClient = ec
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go hs.ListenEvents(ctx)
+
+	// the batched event gives its worker back while it waits for its batch,
+	// until the default timeout abandons it
+	ec.msgs <- &EventMessage{Subject: "groups", Event: &govevents.Event{Action: govevents.GovernorEventUpdate}}
+	ec.msgs <- &EventMessage{Subject: "users", Event: &govevents.Event{Action: govevents.GovernorEventUpdate}}
+
+	sent := make(chan struct{})
+
+	go func() {
+		ec.msgs <- &EventMessage{Subject: "users", Event: &govevents.Event{Action: govevents.GovernorEventUpdate}}
+		close(sent)
+	}()
+
+	// the timed out event does not take the worker of the running one
+	time.Sleep(200 * time.Millisecond) //nolint: mnd
+	assert.Equal(t, int32(1), maxRunning.Load())
+	assert.Len(t, hs.sam, 1)
+
+	close(release)
+	<-sent
+
+	require.Eventually(t, func() bool {
+		return running.Load() == 0 && len(hs.sam) == 0
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, int32(1), maxRunning.Load())
+}
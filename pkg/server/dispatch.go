@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultRecentEventsSize is the default number of processed events kept for
+// the admin API
+const DefaultRecentEventsSize = 100
+
+// Outcome is the outcome of processing an event
+type Outcome string
+
+const (
+	// OutcomeSuccess is the outcome of an event processed without error
+	OutcomeSuccess Outcome = "success"
+	// OutcomeError is the outcome of an event whose handler failed
+	OutcomeError Outcome = "error"
+	// OutcomeTimeout is the outcome of an event whose handler timed out
+	OutcomeTimeout Outcome = "timeout"
+)
+
+// EventInfo describes an event being dispatched to the event router
+type EventInfo struct {
+	Subject       string    `json:"subject"`
+	Action        string    `json:"action"`
+	ResourceID    string    `json:"resource_id,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+}
+
+// InFlightEvent is an event being processed
+type InFlightEvent struct {
+	EventInfo
+	// Age is the time since the event processing started
+	Age string `json:"age"`
+}
+
+// ProcessedEvent is an event that was processed
+type ProcessedEvent struct {
+	EventInfo
+	Outcome  Outcome `json:"outcome"`
+	Error    string  `json:"error,omitempty"`
+	Duration string  `json:"duration"`
+}
+
+// dispatcher keeps track of the events dispatched to the event router and of
+// the paused subjects
+type dispatcher struct {
+	mu       sync.Mutex
+	nextID   uint64
+	inflight map[uint64]EventInfo
+	// subjects holds the events of the paused subjects, and of the resumed
+	// subjects until their held events are dispatched
+	subjects map[string]*heldQueue
+
+	// recent is a ring buffer of the last processed events, next is the
+	// index of the next write
+	recent []ProcessedEvent
+	next   int
+	full   bool
+}
+
+// heldQueue holds the events received on a paused subject, in order
+type heldQueue struct {
+	paused bool
+	// resume is closed when the subject is resumed
+	resume chan struct{}
+	// draining is set while the held events are dispatched
+	draining bool
+	events   []*EventMessage
+}
+
+// newDispatcher creates a dispatcher keeping the last size processed events
+func newDispatcher(size int) *dispatcher {
+	if size <= 0 {
+		size = DefaultRecentEventsSize
+	}
+
+	return &dispatcher{
+		inflight: make(map[uint64]EventInfo),
+		subjects: make(map[string]*heldQueue),
+		recent:   make([]ProcessedEvent, size),
+	}
+}
+
+// start records the start of the event processing, the returned function
+// records its end
+func (d *dispatcher) start(subj string, event *govevents.Event) func(err error) {
+	info := EventInfo{
+		Subject:       subj,
+		Action:        event.Action,
+		ResourceID:    event.ExtensionResourceID,
+		CorrelationID: nats.Header(event.Headers).Get(govevents.GovernorEventCorrelationIDHeader),
+		StartedAt:     time.Now(),
+	}
+
+	d.mu.Lock()
+	id := d.nextID
+	d.nextID++
+	d.inflight[id] = info
+	d.mu.Unlock()
+
+	return func(err error) {
+		pe := ProcessedEvent{
+			EventInfo: info,
+			Outcome:   OutcomeSuccess,
+			Duration:  time.Since(info.StartedAt).String(),
+		}
+
+		if err != nil {
+			pe.Outcome = OutcomeError
+			pe.Error = err.Error()
+
+			if errors.Is(err, eventrouter.ErrHandlerTimeout) {
+				pe.Outcome = OutcomeTimeout
+			}
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		delete(d.inflight, id)
+
+		d.recent[d.next] = pe
+		d.next = (d.next + 1) % len(d.recent)
+
+		if d.next == 0 {
+			d.full = true
+		}
+	}
+}
+
+// InFlight returns the events being processed, oldest first
+func (d *dispatcher) InFlight() []InFlightEvent {
+	now := time.Now()
+
+	d.mu.Lock()
+
+	events := make([]InFlightEvent, 0, len(d.inflight))
+	for _, info := range d.inflight {
+		events = append(events, InFlightEvent{EventInfo: info, Age: now.Sub(info.StartedAt).String()})
+	}
+
+	d.mu.Unlock()
+
+	slices.SortFunc(events, func(a, b InFlightEvent) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+
+	return events
+}
+
+// Recent returns the last processed events, most recent first
+func (d *dispatcher) Recent() []ProcessedEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := d.next
+	if d.full {
+		n = len(d.recent)
+	}
+
+	events := make([]ProcessedEvent, 0, n)
+
+	for i := 1; i <= n; i++ {
+		events = append(events, d.recent[(d.next-i+len(d.recent))%len(d.recent)])
+	}
+
+	return events
+}
+
+// Pause pauses the dispatch of the events on the subject, it reports whether
+// the subject was not paused already
+func (d *dispatcher) Pause(subj string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q, ok := d.subjects[subj]
+	if !ok {
+		q = &heldQueue{}
+		d.subjects[subj] = q
+	}
+
+	if q.paused {
+		return false
+	}
+
+	q.paused = true
+	q.resume = make(chan struct{})
+
+	return true
+}
+
+// Resume resumes the dispatch of the events on the subject, it reports
+// whether the subject was paused, and whether the caller must drain the held
+// events with nextHeld, i.e. no other drain is running
+func (d *dispatcher) Resume(subj string) (resumed, drain bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q, ok := d.subjects[subj]
+	if !ok || !q.paused {
+		return false, false
+	}
+
+	q.paused = false
+	close(q.resume)
+
+	if q.draining {
+		return true, false
+	}
+
+	q.draining = true
+
+	return true, true
+}
+
+// Paused returns the paused subjects, sorted
+func (d *dispatcher) Paused() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subjs := make([]string, 0, len(d.subjects))
+
+	for subj, q := range d.subjects {
+		if q.paused {
+			subjs = append(subjs, subj)
+		}
+	}
+
+	slices.Sort(subjs)
+
+	return subjs
+}
+
+// Held returns the number of events held per subject, for the paused subjects
+// and the subjects whose held events are being drained
+func (d *dispatcher) Held() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	held := make(map[string]int, len(d.subjects))
+	for subj, q := range d.subjects {
+		held[subj] = len(q.events)
+	}
+
+	return held
+}
+
+// hold queues the event if its subject is paused, or if the events held while
+// it was paused are not all dispatched yet, so the events are dispatched in
+// the order they were received. It reports whether the event was held.
+func (d *dispatcher) hold(msg *EventMessage) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q, ok := d.subjects[msg.Subject]
+	if !ok {
+		return false
+	}
+
+	q.events = append(q.events, msg)
+
+	return true
+}
+
+// nextHeld returns the next held event of the resumed subject, it returns false
+// once the queue is empty or the subject is paused again, which ends the drain
+func (d *dispatcher) nextHeld(subj string) (*EventMessage, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q, ok := d.subjects[subj]
+	if !ok {
+		return nil, false
+	}
+
+	if q.paused {
+		q.draining = false
+		return nil, false
+	}
+
+	if len(q.events) == 0 {
+		delete(d.subjects, subj)
+		return nil, false
+	}
+
+	msg := q.events[0]
+	q.events[0] = nil
+	q.events = q.events[1:]
+
+	return msg, true
+}
+
+// wait blocks until the subject is resumed or the context is done, it reports
+// whether the event can be dispatched
+func (d *dispatcher) wait(ctx context.Context, subj string) bool {
+	for {
+		d.mu.Lock()
+		q, ok := d.subjects[subj]
+		paused := ok && q.paused
+
+		var resume chan struct{}
+		if paused {
+			resume = q.resume
+		}
+		d.mu.Unlock()
+
+		if !paused {
+			return true
+		}
+
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -2,5 +2,18 @@ package server
 
 import "errors"
 
-// ErrNoNATSConnection is returned when there is no NATS connection
-var ErrNoNATSConnection = errors.New("no NATS connection")
+var (
+	// ErrNoNATSConnection is returned when there is no NATS connection
+	ErrNoNATSConnection = errors.New("no NATS connection")
+	// ErrMissingAuthIssuer is returned when JWT authentication is configured
+	// without an issuer
+	ErrMissingAuthIssuer = errors.New("missing JWT issuer")
+	// ErrOIDCDiscovery is returned when the OIDC discovery of the JWT issuer
+	// fails
+	ErrOIDCDiscovery = errors.New("failed OIDC discovery")
+	// ErrLoadingTLSCertificate is returned when the TLS certificate or key
+	// cannot be loaded
+	ErrLoadingTLSCertificate = errors.New("failed loading TLS certificate")
+	// ErrLoadingTLSClientCA is returned when the TLS client CA cannot be loaded
+	ErrLoadingTLSClientCA = errors.New("failed loading TLS client CA")
+)
@@ -2,9 +2,15 @@ package server
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
 
 	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -15,16 +21,227 @@ type EventClient interface {
 	Shutdown() error
 }
 
+// Unsubscriber is implemented by event clients that can unsubscribe from a
+// subject, the server uses it when a subject is removed from the event router
+type Unsubscriber interface {
+	Unsubscribe(subject string) error
+}
+
 // EventMessage is a wrapper for a governor event message
 type EventMessage struct {
 	Subject string
 	Event   *govevents.Event
+	// ReceivedAt is the time the event client received the message
+	ReceivedAt time.Time
+	// Attempt is the delivery attempt of the message, starting at 1, or 0 if
+	// the event client does not track redeliveries
+	Attempt int
+}
+
+const (
+	// subscribeRetryMin and subscribeRetryMax bound the backoff of the retries
+	// of failed live subscribes
+	subscribeRetryMin = time.Second
+	subscribeRetryMax = 30 * time.Second
+)
+
+// subscriptionSync keeps the event client subscriptions in sync with the
+// subjects registered with the event router after the initial subscribe. The
+// event client is called without holding the lock, so a slow subscribe does
+// not block the other subject changes.
+type subscriptionSync struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	server   *Server
+	retryMin time.Duration
+	retries  map[string]context.CancelFunc
+	// added are the subjects added since the sync started and not removed
+	// since, a subscribe that completes once its subject is removed is undone
+	added map[string]struct{}
+}
+
+var _ eventrouter.SubjectListener = (*subscriptionSync)(nil)
+
+// start enables live syncing with the given context, subject changes before
+// start are picked up by the initial subscribe. The context is detached from
+// its span, live subscribes start their own traces.
+func (ss *subscriptionSync) start(ctx context.Context) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for _, cancel := range ss.retries {
+		cancel()
+	}
+
+	ss.ctx = trace.ContextWithSpanContext(ctx, trace.SpanContext{})
+	ss.retries = make(map[string]context.CancelFunc)
+
+	if ss.added == nil {
+		ss.added = make(map[string]struct{})
+	}
+}
+
+// SubjectAdded subscribes to a subject that was added to the event router, a
+// failed subscribe is retried in the background
+func (ss *subscriptionSync) SubjectAdded(subj string) {
+	ss.mu.Lock()
+
+	ctx := ss.ctx
+	if ctx == nil {
+		ss.mu.Unlock()
+		return
+	}
+
+	ss.added[subj] = struct{}{}
+
+	ss.mu.Unlock()
+
+	ss.server.logger.Info("subscribing to new subject", zap.String("subject", subj))
+
+	if err := ss.subscribe(ctx, subj); err != nil {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+
+		// the sync was restarted or the subject removed meanwhile
+		if _, ok := ss.added[subj]; ok && ctx == ss.ctx {
+			ss.retry(subj)
+		}
+	}
+}
+
+// SubjectRemoved unsubscribes from a subject that was removed from the event
+// router, if the event client supports it
+func (ss *subscriptionSync) SubjectRemoved(subj string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.ctx == nil {
+		return
+	}
+
+	delete(ss.added, subj)
+
+	if cancel, ok := ss.retries[subj]; ok {
+		cancel()
+		delete(ss.retries, subj)
+	}
+
+	ss.unsubscribe(subj)
+}
+
+func (ss *subscriptionSync) subscribe(ctx context.Context, subj string) error {
+	ctx, span := ss.server.tracer.Start(ctx, "subscribe", trace.WithAttributes(attribute.String("subject", subj)))
+	defer span.End()
+
+	if err := ss.server.eventClient.Subscribe(ctx, subj); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		ss.server.logger.Error("failed subscribing to subject", zap.Error(err), zap.String("subject", subj))
+
+		return err
+	}
+
+	return nil
+}
+
+// unsubscribe unsubscribes from the subject if the event client supports it
+func (ss *subscriptionSync) unsubscribe(subj string) {
+	u, ok := ss.server.eventClient.(Unsubscriber)
+	if !ok {
+		ss.server.logger.Debug("event client cannot unsubscribe, keeping subscription", zap.String("subject", subj))
+		return
+	}
+
+	ss.server.logger.Info("unsubscribing from removed subject", zap.String("subject", subj))
+
+	if err := u.Unsubscribe(subj); err != nil {
+		ss.server.logger.Error("failed unsubscribing from subject", zap.Error(err), zap.String("subject", subj))
+	}
+}
+
+// retry subscribes to the subject in the background with an exponential
+// backoff, until it succeeds, the subject is removed or the context is done.
+// It must be called with the lock held.
+func (ss *subscriptionSync) retry(subj string) {
+	if _, ok := ss.retries[subj]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ss.ctx)
+	ss.retries[subj] = cancel
+
+	delay := ss.retryMin
+	if delay <= 0 {
+		delay = subscribeRetryMin
+	}
+
+	go func() {
+		defer cancel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			ss.server.logger.Info("retrying subscribing to subject", zap.String("subject", subj))
+
+			err := ss.subscribe(ctx, subj)
+
+			if ss.retried(ctx, subj, err) {
+				return
+			}
+
+			delay = min(2*delay, subscribeRetryMax) //nolint: mnd
+		}
+	}()
 }
 
-// Subscribe subscribes to all subjects related to the extension
+// retried handles the outcome of a retried subscribe and reports whether the
+// retries are over. The subject may have been removed while subscribing, its
+// subscription is then undone unless the subject was added again.
+func (ss *subscriptionSync) retried(ctx context.Context, subj string, err error) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ctx.Err() != nil {
+		if _, ok := ss.added[subj]; !ok && err == nil {
+			ss.unsubscribe(subj)
+		}
+
+		return true
+	}
+
+	if err != nil {
+		return false
+	}
+
+	delete(ss.retries, subj)
+
+	return true
+}
+
+// Subscribe subscribes to all subjects related to the extension. If the event
+// router supports subject notifications, subjects added to or removed from
+// the router afterwards are subscribed to and unsubscribed from automatically
+// until the context is done.
 func (s *Server) Subscribe(ctx context.Context) error {
 	s.logger.Info("subscribing to event subjects")
 
+	// start syncing before listing subjects so that no subject added
+	// concurrently is missed, subscribing is idempotent. The listener is only
+	// registered once, subscribing again restarts it with the new context.
+	if n, ok := s.eventRouter.(eventrouter.SubjectNotifier); ok {
+		if s.subSync == nil {
+			s.subSync = &subscriptionSync{server: s, retryMin: s.subscribeRetry}
+			n.AddSubjectListener(s.subSync)
+		}
+
+		s.subSync.start(ctx)
+	}
+
 	ctx, span := s.tracer.Start(ctx, "subscribe")
 	defer span.End()
 
@@ -52,15 +269,16 @@ func (s *Server) ListenEvents(ctx context.Context) {
 		case msg := <-s.eventClient.Messages():
 			s.logger.Info("received governor event")
 
-			s.sam <- struct{}{}
+			// events on paused subjects are queued until the subject is
+			// resumed, without holding a worker
+			if s.dispatcher.hold(msg) {
+				s.logger.Debug("holding event on paused subject", zap.String("subject", msg.Subject))
+				continue
+			}
 
-			go func(ctx context.Context, msg *EventMessage) {
-				defer func() { <-s.sam }()
+			s.sam <- struct{}{}
 
-				if err := s.eventRouter.Process(ctx, msg.Subject, msg.Event); err != nil {
-					s.logger.Error("error processing event", zap.Error(err))
-				}
-			}(ctx, msg)
+			go s.processEvent(ctx, msg)
 
 		case <-ctx.Done():
 			s.logger.Info("context cancelled, shutting down")
@@ -68,3 +286,70 @@ func (s *Server) ListenEvents(ctx context.Context) {
 		}
 	}
 }
+
+// resumeSubject resumes the dispatch of the events on the subject and
+// dispatches the events held while it was paused, it reports whether the
+// subject was paused
+func (s *Server) resumeSubject(subj string) bool {
+	resumed, drain := s.dispatcher.Resume(subj)
+	if drain {
+		go s.drainHeld(subj)
+	}
+
+	return resumed
+}
+
+// drainHeld dispatches the held events of the subject in order, until the
+// queue is empty or the subject is paused again. The events are processed
+// with the context of the deferred events, cancelled on shutdown.
+func (s *Server) drainHeld(subj string) {
+	ctx := s.deferred.ctx
+
+	for {
+		msg, ok := s.dispatcher.nextHeld(subj)
+		if !ok {
+			return
+		}
+
+		select {
+		case s.sam <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		go s.processEvent(ctx, msg)
+	}
+}
+
+// processEvent processes the event message with the event router and releases
+// the worker acquired by the caller
+func (s *Server) processEvent(ctx context.Context, msg *EventMessage) {
+	w := &worker{sam: s.sam}
+	defer w.finish()
+
+	if !msg.ReceivedAt.IsZero() {
+		ctx = eventrouter.SaveReceivedAtToContext(ctx, msg.ReceivedAt)
+	}
+
+	if msg.Attempt > 0 {
+		ctx = eventrouter.SaveDeliveryAttemptToContext(ctx, msg.Attempt)
+	}
+
+	ctx = eventrouter.SaveDispatcherToContext(ctx, eventDispatcher{s.deferred, w})
+
+	done := s.dispatcher.start(msg.Subject, msg.Event)
+
+	err := s.eventRouter.Process(ctx, msg.Subject, msg.Event)
+
+	done(err)
+
+	switch {
+	case err == nil:
+	case errors.Is(err, eventrouter.ErrHandlerTimeout):
+		s.logger.Warn("timed out processing event", zap.Error(err), zap.String("subject", msg.Subject))
+	case errors.Is(err, eventrouter.ErrEventInFlight):
+		s.logger.Warn("duplicate of an event being processed", zap.Error(err), zap.String("subject", msg.Subject))
+	default:
+		s.logger.Error("error processing event", zap.Error(err))
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var errSubscribe = errors.New("subscribe failed")
+
+func noopHandler(context.Context, *govevents.Event) error { return nil }
+
+// syncEventClient records the subscribed subjects and the number of
+// unsubscribes, subscribing to a subject in failures fails that many times
+type syncEventClient struct {
+	testEventClient
+
+	mu       sync.Mutex
+	subs     map[string]int
+	unsubs   map[string]int
+	failures map[string]int
+}
+
+func (c *syncEventClient) Subscribe(_ context.Context, subj string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures[subj] > 0 {
+		c.failures[subj]--
+		return errSubscribe
+	}
+
+	c.subs[subj]++
+
+	return nil
+}
+
+func (c *syncEventClient) Unsubscribe(subj string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.subs, subj)
+
+	if c.unsubs != nil {
+		c.unsubs[subj]++
+	}
+
+	return nil
+}
+
+func (c *syncEventClient) unsubscribes(subj string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.unsubs[subj]
+}
+
+func (c *syncEventClient) subscriptions() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := make(map[string]int, len(c.subs))
+	for k, v := range c.subs {
+		subs[k] = v
+	}
+
+	return subs
+}
+
+func TestSubscribeAddRemove(t *testing.T) {
+	ec := &syncEventClient{subs: map[string]int{}, failures: map[string]int{"apps": 2}}
+	router := eventrouter.NewRouter()
+	router.Create("groups", noopHandler)
+
+	hs := NewServer("", "", "", WithEventRouter(router), WithTracer(noop.NewTracerProvider().Tracer("")))
+	hs.eventClient = ec
+	hs.subscribeRetry = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// subscribing twice registers a single subject listener
+	require.NoError(t, hs.Subscribe(ctx))
+	require.NoError(t, hs.Subscribe(ctx))
+	assert.Equal(t, map[string]int{"groups": 2}, ec.subscriptions())
+
+	router.Create("users", noopHandler)
+	assert.Equal(t, map[string]int{"groups": 2, "users": 1}, ec.subscriptions())
+
+	router.Remove(govevents.GovernorEventCreate, "users")
+	assert.Equal(t, map[string]int{"groups": 2}, ec.subscriptions())
+
+	// failed subscribes are retried
+	router.Create("apps", noopHandler)
+
+	require.Eventually(t, func() bool {
+		return ec.subscriptions()["apps"] == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestSubscribeRetryStopsOnRemove(t *testing.T) {
+	ec := &syncEventClient{subs: map[string]int{}, failures: map[string]int{"apps": 1}}
+	router := eventrouter.NewRouter()
+
+	hs := NewServer("", "", "", WithEventRouter(router), WithTracer(noop.NewTracerProvider().Tracer("")))
+	hs.eventClient = ec
+	hs.subscribeRetry = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, hs.Subscribe(ctx))
+
+	router.Create("apps", noopHandler)
+	router.Remove(govevents.GovernorEventCreate, "apps")
+
+	hs.subSync.mu.Lock()
+	defer hs.subSync.mu.Unlock()
+
+	assert.Empty(t, hs.subSync.retries)
+}
+
+func TestSubscribeWithoutUnsubscriber(t *testing.T) {
+	router := eventrouter.NewRouter()
+
+	hs := NewServer("", "", "", WithEventRouter(router), WithTracer(noop.NewTracerProvider().Tracer("")))
+	hs.eventClient = &testEventClient{}
+
+	require.NoError(t, hs.Subscribe(context.Background()))
+
+	router.Create("groups", noopHandler)
+
+	// removing a subject is a no-op for event clients that cannot unsubscribe
+	router.Remove(govevents.GovernorEventCreate, "groups")
+}
+
+// blockingEventClient blocks every subscribe to a subject until the channel
+// it sends on blocked is closed
+type blockingEventClient struct {
+	*syncEventClient
+
+	subj    string
+	blocked chan chan struct{}
+}
+
+func (c *blockingEventClient) Subscribe(ctx context.Context, subj string) error {
+	if subj == c.subj {
+		release := make(chan struct{})
+		c.blocked <- release
+		<-release
+	}
+
+	return c.syncEventClient.Subscribe(ctx, subj)
+}
+
+func TestSubscribeRetryDoesNotBlock(t *testing.T) {
+	ec := &blockingEventClient{
+		syncEventClient: &syncEventClient{subs: map[string]int{}, unsubs: map[string]int{}, failures: map[string]int{"apps": 1}},
+		subj:            "apps",
+		blocked:         make(chan chan struct{}),
+	}
+
+	router := eventrouter.NewRouter()
+
+	hs := NewServer("", "", "", WithEventRouter(router), WithTracer(noop.NewTracerProvider().Tracer("")))
+	hs.eventClient = ec
+	hs.subscribeRetry = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, hs.Subscribe(ctx))
+
+	go router.Create("apps", noopHandler)
+
+	// the first subscribe fails, the retry blocks
+	close(<-ec.blocked)
+	release := <-ec.blocked
+
+	// other subjects are synced while the retry is blocked
+	router.Create("users", noopHandler)
+	assert.Equal(t, map[string]int{"users": 1}, ec.subscriptions())
+
+	// the subject is removed while the retry is subscribing, its subscription
+	// is undone
+	router.Remove(govevents.GovernorEventCreate, "apps")
+	close(release)
+
+	require.Eventually(t, func() bool {
+		return ec.unsubscribes("apps") == 2 //nolint: mnd
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, map[string]int{"users": 1}, ec.subscriptions())
+}
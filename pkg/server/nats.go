@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	govevents "github.com/metal-toolbox/governor-api/pkg/events/v1alpha1"
 	"github.com/nats-io/nats.go"
@@ -22,12 +24,16 @@ type NATSClient struct {
 	queueSize  int
 	tracer     trace.Tracer
 
-	subscriptions []*nats.Subscription
+	mu            sync.Mutex
+	subscriptions map[string][]*nats.Subscription
 	messagesChan  chan *EventMessage
 }
 
-// NATSClient implements the EventClient interface
-var _ EventClient = &NATSClient{}
+// NATSClient implements the EventClient and Unsubscriber interfaces
+var (
+	_ EventClient  = &NATSClient{}
+	_ Unsubscriber = &NATSClient{}
+)
 
 // NATSOption is a functional configuration option for NATS
 type NATSOption func(c *NATSClient)
@@ -36,7 +42,7 @@ type NATSOption func(c *NATSClient)
 func NewNATSClient(opts ...NATSOption) (*NATSClient, error) {
 	client := NATSClient{
 		logger:        zap.NewNop(),
-		subscriptions: []*nats.Subscription{},
+		subscriptions: make(map[string][]*nats.Subscription),
 		messagesChan:  make(chan *EventMessage),
 	}
 
@@ -93,29 +99,71 @@ func (c *NATSClient) Shutdown() error {
 
 	c.logger.Info("shutting down NATS client")
 
-	for _, sub := range c.subscriptions {
+	c.mu.Lock()
+
+	for subject, subs := range c.subscriptions {
+		c.unsubscribe(subs)
+		delete(c.subscriptions, subject)
+	}
+
+	c.mu.Unlock()
+
+	return c.conn.Drain()
+}
+
+// Unsubscribe removes all subscriptions to the NATS subject, it is a no-op if
+// the client is not subscribed to the subject
+func (c *NATSClient) Unsubscribe(subject string) error {
+	if c.conn == nil {
+		return ErrNoNATSConnection
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs, ok := c.subscriptions[subject]
+	if !ok {
+		return nil
+	}
+
+	c.unsubscribe(subs)
+	delete(c.subscriptions, subject)
+
+	return nil
+}
+
+func (c *NATSClient) unsubscribe(subs []*nats.Subscription) {
+	for _, sub := range subs {
 		c.logger.Info("unsubscribing from NATS", zap.String("subject", sub.Subject))
 
 		if err := sub.Unsubscribe(); err != nil {
 			c.logger.Warn("error unsubscribing from NATS", zap.Error(err), zap.String("subject", sub.Subject))
 		}
 	}
-
-	return c.conn.Drain()
 }
 
-// Subscribe creates a subscription to the NATS subject
+// Subscribe creates a subscription to the NATS subject, subscribing to a
+// subject that the client is already subscribed to is a no-op
 func (c *NATSClient) Subscribe(ctx context.Context, subject string) error {
 	if c.conn == nil {
 		return ErrNoNATSConnection
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.subscriptions[subject]; ok {
+		return nil
+	}
+
 	_, span := c.tracer.Start(ctx, "natsclient-subscribe", trace.WithAttributes(
 		attribute.String("subject", subject),
 	))
 	defer span.End()
 
 	handler := func(msg *nats.Msg) {
+		receivedAt := time.Now()
+
 		c.logger.Info("received message", zap.String("subject", msg.Subject))
 
 		event := &govevents.Event{}
@@ -128,18 +176,28 @@ func (c *NATSClient) Subscribe(ctx context.Context, subject string) error {
 		event.Headers = msg.Header
 		msg.Subject = strings.TrimPrefix(msg.Subject, c.prefix+".")
 
-		c.messagesChan <- &EventMessage{msg.Subject, event}
+		// core NATS subscriptions do not redeliver messages
+		c.messagesChan <- &EventMessage{
+			Subject:    msg.Subject,
+			Event:      event,
+			ReceivedAt: receivedAt,
+			Attempt:    1,
+		}
 	}
 
+	subs := make([]*nats.Subscription, 0, c.queueSize)
+
 	for i := 0; i < c.queueSize; i++ {
 		subj := fmt.Sprintf("%s.%s", c.prefix, subject)
 
 		subscription, err := c.conn.QueueSubscribe(subj, c.queueGroup, handler)
 		if err != nil {
+			// don't leave a partial set of queue subscriptions behind
+			c.unsubscribe(subs)
 			return err
 		}
 
-		c.subscriptions = append(c.subscriptions, subscription)
+		subs = append(subs, subscription)
 
 		c.logger.Debug(
 			"subscribed to NATS subject",
@@ -148,6 +206,8 @@ func (c *NATSClient) Subscribe(ctx context.Context, subject string) error {
 		)
 	}
 
+	c.subscriptions[subject] = subs
+
 	return nil
 }
 
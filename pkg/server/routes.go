@@ -0,0 +1,52 @@
+package server
+
+import "github.com/gin-gonic/gin"
+
+// Routes registers HTTP routes on a gin router group
+type Routes func(rg *gin.RouterGroup)
+
+// routeGroup is a group of routes registered with WithRoutes or
+// WithAdminRoutes
+type routeGroup struct {
+	path       string
+	routes     Routes
+	middleware []gin.HandlerFunc
+	// requireAuth keeps the group off the HTTP listener when authentication
+	// is disabled
+	requireAuth bool
+}
+
+// WithRoutes registers the routes under the path on the HTTP listener, with
+// the middleware applied to the group. The routes are served with the same
+// CORS, logging, tracing and metrics middleware as the health endpoints.
+func WithRoutes(path string, routes Routes, mw ...gin.HandlerFunc) Option {
+	return func(s *Server) {
+		s.routeGroups = append(s.routeGroups, routeGroup{path: path, routes: routes, middleware: mw})
+	}
+}
+
+// WithAdminRoutes registers the routes under the path on the admin listener,
+// with the middleware applied to the group. Without an admin listener the
+// routes are served by the HTTP listener.
+func WithAdminRoutes(path string, routes Routes, mw ...gin.HandlerFunc) Option {
+	return func(s *Server) {
+		s.adminRouteGroups = append(s.adminRouteGroups, routeGroup{path: path, routes: routes, middleware: mw})
+	}
+}
+
+// WithHTTPMiddleware adds middleware applied to the routes registered with
+// WithRoutes and WithAdminRoutes, the health and metrics endpoints are not
+// affected.
+func WithHTTPMiddleware(mw ...gin.HandlerFunc) Option {
+	return func(s *Server) {
+		s.httpMiddleware = append(s.httpMiddleware, mw...)
+	}
+}
+
+// WithAdminListen sets the address and port of the admin listener, the admin
+// routes are served by the HTTP listener when it is empty
+func WithAdminListen(listen string) Option {
+	return func(s *Server) {
+		s.adminListen = listen
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"net/http"
@@ -12,15 +13,18 @@ import (
 	governor "github.com/metal-toolbox/governor-api/pkg/client"
 	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventprocessor"
 	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter"
+	"github.com/metal-toolbox/governor-extension-sdk/pkg/eventrouter/historycache"
 
 	"github.com/gin-contrib/cors"
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	ginprometheus "github.com/zsais/go-gin-prometheus"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
 )
 
@@ -53,9 +57,43 @@ type Server struct {
 	status         Status
 	tracer         trace.Tracer
 
-	eventRouter eventrouter.EventRouter
-	processors  []eventprocessor.EventProcessor
-	sam         chan struct{}
+	eventRouter  eventrouter.EventRouter
+	processors   []eventprocessor.EventProcessor
+	sam          chan struct{}
+	eventTimeout time.Duration
+	histcache    historycache.HistoryCache
+	selfActors   []string
+	skipRules    []eventrouter.SkipRule
+	eventFilter  *eventrouter.EventFilter
+
+	prom             *ginprometheus.Prometheus
+	httpMiddleware   []gin.HandlerFunc
+	routeGroups      []routeGroup
+	adminListen      string
+	adminRouteGroups []routeGroup
+
+	unauthenticatedAdminAPI bool
+
+	dispatcher       *dispatcher
+	recentEventsSize int
+
+	cors           *cors.Config
+	auth           *JWTAuth
+	adminScopes    []string
+	protectHealth  bool
+	protectMetrics bool
+
+	tlsConfig *TLSConfig
+	certs     *certReloader
+
+	subSync        *subscriptionSync
+	subscribeRetry time.Duration
+
+	deferred *deferredDispatcher
+
+	// closers are closed once the server is shut down, e.g. the history cache
+	// created by NewServerFromConfig
+	closers []io.Closer
 }
 
 // Option is a function that configures a Server
@@ -84,16 +122,52 @@ func NewServer(
 	}
 
 	s.logger = s.logger.With(zap.String("component", "server"))
+	s.dispatcher = newDispatcher(s.recentEventsSize)
+	s.deferred = newDeferredDispatcher(s)
+
+	if s.tracer == nil {
+		s.tracer = noop.NewTracerProvider().Tracer(tracerName)
+	}
+
+	if s.eventRouter != nil && (len(s.skipRules) > 0 || s.eventFilter != nil) {
+		s.logger.Warn("skip rules and event filter are ignored with a custom event router")
+	}
 
 	if s.eventRouter == nil {
-		s.eventRouter = eventrouter.NewRouter(
+		cidpOpts := []eventrouter.CorrelationIDProcessorOpt{
+			eventrouter.CorrelationIDProcessorWithLogger(s.logger),
+			eventrouter.CorrelationIDProcessorWithSkipStrategyUpdateOnly(),
+		}
+
+		if len(s.skipRules) > 0 {
+			cidpOpts = append(cidpOpts, eventrouter.CorrelationIDProcessorWithSkipRules(s.skipRules...))
+		}
+
+		// the history cache is kept so it can be inspected with the admin API
+		if s.histcache == nil {
+			s.histcache = historycache.NewLocalCache(historycache.WithName("correlation-id"))
+		}
+
+		cidpOpts = append(cidpOpts, eventrouter.CorrelationIDProcessorWithHistoryCache(s.histcache))
+
+		if len(s.selfActors) > 0 {
+			cidpOpts = append(cidpOpts, eventrouter.CorrelationIDProcessorWithSelfActors(s.selfActors...))
+		}
+
+		routerOpts := []eventrouter.Option{
 			eventrouter.WithLogger(s.logger),
 			eventrouter.WithTracer(s.tracer),
-			eventrouter.WithCorrelationIDProcessor(eventrouter.NewCorrelationIDProcessor(
-				eventrouter.CorrelationIDProcessorWithLogger(s.logger),
-				eventrouter.CorrelationIDProcessorWithSkipStrategyUpdateOnly(),
-			)),
-		)
+			eventrouter.WithDefaultTimeout(s.eventTimeout),
+			eventrouter.WithCorrelationIDProcessor(eventrouter.NewCorrelationIDProcessor(cidpOpts...)),
+		}
+
+		// the filter is applied last so it runs before the correlation ID
+		// processor records the filtered events
+		if s.eventFilter != nil {
+			routerOpts = append(routerOpts, eventrouter.WithMiddleware(s.eventFilter.MWFilter))
+		}
+
+		s.eventRouter = eventrouter.NewRouter(routerOpts...)
 	}
 
 	return s
@@ -113,6 +187,56 @@ func WithMaxWorkers(num int) Option {
 	}
 }
 
+// WithEventTimeout sets the default timeout for event handlers, it is only
+// used when the server constructs the event router
+func WithEventTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.eventTimeout = d
+	}
+}
+
+// WithCorrelationIDHistoryCache sets the history cache of the correlation ID
+// processor, it is only used when the server constructs the event router
+func WithCorrelationIDHistoryCache(hc historycache.HistoryCache) Option {
+	return func(s *Server) {
+		s.histcache = hc
+	}
+}
+
+// withCloser closes c once the server is shut down
+func withCloser(c io.Closer) Option {
+	return func(s *Server) {
+		s.closers = append(s.closers, c)
+	}
+}
+
+// WithSkipRules sets the skip rules of the correlation ID processor instead of
+// the default update only strategy. It is only used when the server constructs
+// the event router.
+func WithSkipRules(rules ...eventrouter.SkipRule) Option {
+	return func(s *Server) {
+		s.skipRules = rules
+	}
+}
+
+// WithEventFilter filters the events before they are processed by any route,
+// events not matching the filter are not recorded in the history cache. It is
+// only used when the server constructs the event router.
+func WithEventFilter(f *eventrouter.EventFilter) Option {
+	return func(s *Server) {
+		s.eventFilter = f
+	}
+}
+
+// WithSelfActorIDs sets the actor IDs of the extension's own identities, the
+// events they cause are skipped according to the skip strategy. It is only used
+// when the server constructs the event router.
+func WithSelfActorIDs(ids ...string) Option {
+	return func(s *Server) {
+		s.selfActors = ids
+	}
+}
+
 // WithEventRouter sets the event router for the server
 func WithEventRouter(er eventrouter.EventRouter) Option {
 	return func(s *Server) {
@@ -155,6 +279,14 @@ func WithNATSClient(c *NATSClient) Option {
 	}
 }
 
+// WithCORS sets the CORS policy of the HTTP and admin listeners, by default
+// all origins are allowed without credentials
+func WithCORS(cfg cors.Config) Option {
+	return func(s *Server) {
+		s.cors = &cfg
+	}
+}
+
 // WithTracer sets the tracer for the server
 func WithTracer(t trace.Tracer) Option {
 	return func(s *Server) {
@@ -169,37 +301,63 @@ var (
 	shutdownTimeout = 5 * time.Second
 )
 
-func (s *Server) setup() *gin.Engine {
-	// Setup default gin router
-	r := gin.New()
+// healthPaths are the paths of the health endpoints, they are not logged
+var healthPaths = []string{"/healthz", "/healthz/readiness", "/healthz/liveness"}
 
-	r.Use(cors.New(cors.Config{
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
-		AllowAllOrigins:  true,
-		AllowCredentials: true,
-		MaxAge:           corsMaxAge,
-	}))
+// metrics returns the gin prometheus middleware, it is shared by the HTTP and
+// admin listeners as the metrics can only be registered once
+func (s *Server) metrics() *ginprometheus.Prometheus {
+	if s.prom != nil {
+		return s.prom
+	}
 
-	p := ginprometheus.NewPrometheus("gin")
+	s.prom = ginprometheus.NewPrometheus("gin")
 
 	// Remove any params from the URL string to keep the number of labels down
-	p.ReqCntURLLabelMappingFn = func(c *gin.Context) string {
+	s.prom.ReqCntURLLabelMappingFn = func(c *gin.Context) string {
 		return c.FullPath()
 	}
 
-	p.Use(r)
+	return s.prom
+}
+
+// newEngine creates a gin engine with the CORS, metrics, logging, recovery and
+// tracing middleware, metricsPath exposes the metrics endpoint
+func (s *Server) newEngine(component string, metricsPath bool) *gin.Engine {
+	r := gin.New()
+
+	corscfg := cors.Config{
+		AllowMethods:    []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"},
+		AllowHeaders:    []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		AllowAllOrigins: true,
+		MaxAge:          corsMaxAge,
+	}
 
-	customLogger := s.logger.With(zap.String("component", "httpsrv"))
+	if s.cors != nil {
+		corscfg = *s.cors
+	}
+
+	r.Use(cors.New(corscfg))
+
+	p := s.metrics()
+	r.Use(p.HandlerFunc())
+
+	// the metrics endpoint is registered before the logging middleware so
+	// scrapes are not logged
+	if metricsPath {
+		r.GET(p.MetricsPath, append(s.authMiddleware(s.protectMetrics), gin.WrapH(promhttp.Handler()))...)
+	}
+
+	customLogger := s.logger.With(zap.String("component", component))
 	r.Use(
 		ginzap.GinzapWithConfig(customLogger, &ginzap.Config{
 			TimeFormat: time.RFC3339,
-			SkipPaths:  []string{"/healthz", "/healthz/readiness", "/healthz/liveness"},
+			SkipPaths:  healthPaths,
 			UTC:        true,
 		}),
 	)
 
-	r.Use(ginzap.RecoveryWithZap(s.logger.With(zap.String("component", "httpsrv")), true))
+	r.Use(ginzap.RecoveryWithZap(customLogger, true))
 
 	tp := otel.GetTracerProvider()
 	if tp != nil {
@@ -211,11 +369,6 @@ func (s *Server) setup() *gin.Engine {
 		r.Use(otelgin.Middleware(hostname, otelgin.WithTracerProvider(tp)))
 	}
 
-	// Health endpoints
-	r.GET("/healthz", s.livenessCheck)
-	r.GET("/healthz/liveness", s.livenessCheck)
-	r.GET("/healthz/readiness", s.readinessCheck)
-
 	r.NoRoute(func(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"message": "invalid request - route not found"})
 	})
@@ -223,6 +376,85 @@ func (s *Server) setup() *gin.Engine {
 	return r
 }
 
+func (s *Server) setup() *gin.Engine {
+	r := s.newEngine("httpsrv", true)
+
+	// Health endpoints
+	health := r.Group("/healthz", s.authMiddleware(s.protectHealth)...)
+	health.GET("", s.livenessCheck)
+	health.GET("/liveness", s.livenessCheck)
+	health.GET("/readiness", s.readinessCheck)
+
+	s.registerRouteGroups(r, s.routeGroups)
+
+	// admin routes are served by the HTTP listener when there is no admin
+	// listener
+	if s.adminListen == "" {
+		s.registerRouteGroups(r, s.publicAdminRouteGroups(), s.adminScopes...)
+	}
+
+	return r
+}
+
+// publicAdminRouteGroups returns the admin route groups that can be served by
+// the HTTP listener, the groups requiring authentication are left out when it
+// is disabled
+func (s *Server) publicAdminRouteGroups() []routeGroup {
+	if s.auth != nil || s.unauthenticatedAdminAPI {
+		return s.adminRouteGroups
+	}
+
+	groups := make([]routeGroup, 0, len(s.adminRouteGroups))
+
+	for _, g := range s.adminRouteGroups {
+		if !g.requireAuth {
+			groups = append(groups, g)
+			continue
+		}
+
+		s.logger.Error(
+			"not serving the admin API on the HTTP listener without authentication, "+
+				"set an admin listener, enable JWT authentication or allow it with WithUnauthenticatedAdminAPI",
+			zap.String("path", g.path),
+		)
+	}
+
+	return groups
+}
+
+func (s *Server) setupAdmin() *gin.Engine {
+	r := s.newEngine("adminsrv", false)
+
+	s.registerRouteGroups(r, s.adminRouteGroups, s.adminScopes...)
+
+	return r
+}
+
+// authMiddleware returns the JWT authentication middleware requiring the
+// scopes, if authentication is enabled and protected
+func (s *Server) authMiddleware(protected bool, scopes ...string) []gin.HandlerFunc {
+	if s.auth == nil || !protected {
+		return nil
+	}
+
+	return []gin.HandlerFunc{s.auth.Middleware(scopes...)}
+}
+
+// registerRouteGroups registers the route groups on the engine, after the
+// JWT authentication requiring the scopes and the middleware set with
+// WithHTTPMiddleware
+func (s *Server) registerRouteGroups(r *gin.Engine, groups []routeGroup, scopes ...string) {
+	if len(groups) == 0 {
+		return
+	}
+
+	base := r.Group("/", append(s.authMiddleware(true, scopes...), s.httpMiddleware...)...)
+
+	for _, g := range groups {
+		g.routes(base.Group(g.path, g.middleware...))
+	}
+}
+
 func (s *Server) newHTTPServer() *http.Server {
 	if !s.Debug {
 		gin.SetMode(gin.ReleaseMode)
@@ -233,18 +465,78 @@ func (s *Server) newHTTPServer() *http.Server {
 		Addr:         s.Listen,
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
+		TLSConfig:    s.serverTLSConfig(),
+	}
+}
+
+// newAdminHTTPServer returns the admin HTTP server, or nil if there is no
+// admin listener
+func (s *Server) newAdminHTTPServer() *http.Server {
+	if s.adminListen == "" {
+		return nil
+	}
+
+	if !s.Debug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	return &http.Server{
+		Handler:      s.setupAdmin(),
+		Addr:         s.adminListen,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		TLSConfig:    s.serverTLSConfig(),
+	}
+}
+
+// serverTLSConfig returns the TLS configuration of the listeners, or nil if
+// TLS is disabled
+func (s *Server) serverTLSConfig() *tls.Config {
+	if s.certs == nil {
+		return nil
+	}
+
+	return s.certs.tlsConfig()
+}
+
+// listenAndServe serves the HTTP server, over TLS when it is configured
+func listenAndServe(srv *http.Server) {
+	var err error
+
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(err)
 	}
 }
 
 // Run will start the server listening on the specified address
 func (s *Server) Run(ctx context.Context) error {
-	httpsrv := s.newHTTPServer()
+	if s.tlsConfig != nil {
+		certs, err := newCertReloader(*s.tlsConfig, s.logger)
+		if err != nil {
+			return err
+		}
 
-	go func() {
-		if err := httpsrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			panic(err)
+		if err := certs.watch(ctx); err != nil {
+			return err
 		}
-	}()
+
+		s.certs = certs
+	}
+
+	httpsrv := s.newHTTPServer()
+
+	go listenAndServe(httpsrv)
+
+	adminsrv := s.newAdminHTTPServer()
+	if adminsrv != nil {
+		go listenAndServe(adminsrv)
+	}
 
 	startupCtx, span := s.tracer.Start(ctx, "server-startup")
 	defer span.End()
@@ -276,10 +568,26 @@ func (s *Server) Run(ctx context.Context) error {
 		return err
 	}
 
+	if adminsrv != nil {
+		if err := adminsrv.Shutdown(shutdownctx); err != nil {
+			return err
+		}
+	}
+
+	// dispatch the events held by the event router middlewares before the
+	// event client goes away
+	s.deferred.Shutdown()
+
 	if err := s.eventClient.Shutdown(); err != nil {
 		return err
 	}
 
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+
 	s.logger.Info("server shutdown cleanly", zap.String("time", time.Now().UTC().Format(time.RFC3339)))
 
 	return nil
@@ -293,8 +601,31 @@ func (s *Server) livenessCheck(c *gin.Context) {
 }
 
 // readinessCheck ensures that the server is up and that we are able to process requests.
+// When TLS is enabled, the expiry of the certificate is reported and the
+// server is not ready once it has expired.
 func (s *Server) readinessCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	if s.certs == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"status": s.status,
+		})
+
+		return
+	}
+
+	notAfter := s.certs.NotAfter()
+	expiresIn := time.Until(notAfter)
+
+	code := http.StatusOK
+	if expiresIn <= 0 {
+		code = http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, gin.H{
 		"status": s.status,
+		"tls": gin.H{
+			"not_after":  notAfter.UTC().Format(time.RFC3339),
+			"expires_in": expiresIn.Round(time.Second).String(),
+			"expired":    expiresIn <= 0,
+		},
 	})
 }
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
@@ -76,3 +77,75 @@ func TestReadinessRouteUp(t *testing.T) {
 	assert.Equal(t, 200, w.Code)
 	assert.Equal(t, `{"status":"UP"}`, w.Body.String())
 }
+
+func TestCustomRoutes(t *testing.T) {
+	hs := NewServer(
+		"", "", "",
+		WithHTTPMiddleware(func(c *gin.Context) { c.Header("X-Test", "global") }),
+		WithRoutes("/api", func(rg *gin.RouterGroup) {
+			rg.GET("/hello", func(c *gin.Context) { c.String(http.StatusOK, "hello") })
+		}, func(c *gin.Context) { c.Header("X-Group", "api") }),
+		WithAdminRoutes("/admin", func(rg *gin.RouterGroup) {
+			rg.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+		}),
+	)
+	router := hs.newHTTPServer().Handler
+
+	assert.Nil(t, hs.newAdminHTTPServer())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.TODO(), "GET", "/api/hello", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Equal(t, "global", w.Header().Get("X-Test"))
+	assert.Equal(t, "api", w.Header().Get("X-Group"))
+
+	// admin routes are served by the HTTP listener without an admin listener
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequestWithContext(context.TODO(), "GET", "/admin/ping", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "pong", w.Body.String())
+
+	// the health endpoints are not affected by the HTTP middleware
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequestWithContext(context.TODO(), "GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get("X-Test"))
+}
+
+func TestAdminListener(t *testing.T) {
+	hs := NewServer(
+		"", "", "",
+		WithAdminListen("127.0.0.1:0"),
+		WithAdminRoutes("/admin", func(rg *gin.RouterGroup) {
+			rg.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+		}),
+	)
+
+	adminsrv := hs.newAdminHTTPServer()
+	if !assert.NotNil(t, adminsrv) {
+		return
+	}
+
+	assert.Equal(t, "127.0.0.1:0", adminsrv.Addr)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.TODO(), "GET", "/admin/ping", nil)
+	adminsrv.Handler.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "pong", w.Body.String())
+
+	// admin routes are not served by the HTTP listener
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequestWithContext(context.TODO(), "GET", "/admin/ping", nil)
+	hs.newHTTPServer().Handler.ServeHTTP(w, req)
+
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// TLSConfig holds the TLS configuration of the HTTP and admin listeners
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded certificate and key files
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the PEM encoded CA file verifying the client
+	// certificates, client certificates are required when it is set
+	ClientCAFile string
+	// MinVersion is the minimum TLS version, TLS 1.2 when zero
+	MinVersion uint16
+}
+
+// WithTLS serves the HTTP and admin listeners over TLS. The certificate, key
+// and client CA files are reloaded when they change.
+func WithTLS(cfg TLSConfig) Option {
+	return func(s *Server) {
+		s.tlsConfig = &cfg
+	}
+}
+
+// certReloader serves the certificate and client CAs loaded from files,
+// reloading them when the files change
+type certReloader struct {
+	cfg    TLSConfig
+	logger *zap.Logger
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	notAfter  time.Time
+}
+
+// newCertReloader loads the certificate and client CAs of the configuration
+func newCertReloader(cfg TLSConfig, logger *zap.Logger) (*certReloader, error) {
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	r := &certReloader{cfg: cfg, logger: logger.With(zap.String("component", "tls"))}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// load loads the certificate and client CAs from their files
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrLoadingTLSCertificate, err)
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("%w: %w", ErrLoadingTLSCertificate, err)
+		}
+	}
+
+	var pool *x509.CertPool
+
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrLoadingTLSClientCA, err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: no certificate found in %s", ErrLoadingTLSClientCA, r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.notAfter = leaf.NotAfter
+	r.mu.Unlock()
+
+	r.logger.Info(
+		"loaded TLS certificate",
+		zap.String("subject", leaf.Subject.String()),
+		zap.Time("not-after", leaf.NotAfter),
+	)
+
+	return nil
+}
+
+// tlsConfig returns the TLS configuration of the listeners
+func (r *certReloader) tlsConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:     r.cfg.MinVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: r.getCertificate,
+	}
+
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return r.getConfigForClient(base), nil
+	}
+
+	return base
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// getConfigForClient returns a copy of the listener's configuration with the
+// current client CAs, they cannot be swapped in the listener's configuration
+func (r *certReloader) getConfigForClient(base *tls.Config) *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cfg := base.Clone()
+	cfg.GetConfigForClient = nil
+
+	if r.clientCAs != nil {
+		cfg.ClientCAs = r.clientCAs
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg
+}
+
+// NotAfter returns the expiry of the current certificate
+func (r *certReloader) NotAfter() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.notAfter
+}
+
+// watch reloads the files when they change until the context is done. The
+// directories of the files are watched, so files replaced by renames or
+// symlink swaps, e.g. Kubernetes secrets, are reloaded too. A failed reload
+// keeps the current certificate.
+func (r *certReloader) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+
+	var dirs []string
+
+	for _, f := range files {
+		dir := filepath.Dir(f)
+		if slices.Contains(dirs, dir) {
+			continue
+		}
+
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+
+		dirs = append(dirs, dir)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+
+				r.logger.Debug("TLS file changed", zap.String("file", event.Name))
+
+				if err := r.load(); err != nil {
+					r.logger.Warn("failed reloading TLS certificate, keeping the current one", zap.Error(err))
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				r.logger.Warn("TLS file watcher error", zap.Error(err))
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour), //nolint: mnd
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM encoded certificate and key of a new leaf certificate
+func (ca *testCA) issue(t *testing.T, cn string, notAfter time.Time, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, data, 0o600)) //nolint: mnd
+}
+
+func TestTLSReadiness(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	notAfter := time.Now().Add(2 * time.Hour).Truncate(time.Second) //nolint: mnd
+
+	certPEM, keyPEM := ca.issue(t, "server", notAfter, x509.ExtKeyUsageServerAuth)
+	writeFile(t, filepath.Join(dir, "tls.crt"), certPEM)
+	writeFile(t, filepath.Join(dir, "tls.key"), keyPEM)
+
+	certs, err := newCertReloader(TLSConfig{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	hs := Server{logger: zap.NewNop(), status: StatusUp, certs: certs}
+	h := hs.newHTTPServer().Handler
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(context.TODO(), http.MethodGet, "/healthz/readiness", nil)
+	h.ServeHTTP(w, req)
+
+	var resp struct {
+		Status string
+		TLS    struct {
+			NotAfter time.Time `json:"not_after"`
+			Expired  bool
+		}
+	}
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "UP", resp.Status)
+	assert.True(t, notAfter.Equal(resp.TLS.NotAfter))
+	assert.False(t, resp.TLS.Expired)
+
+	// an expired certificate makes the server not ready
+	certPEM, keyPEM = ca.issue(t, "server", time.Now().Add(-time.Minute), x509.ExtKeyUsageServerAuth)
+	writeFile(t, filepath.Join(dir, "tls.crt"), certPEM)
+	writeFile(t, filepath.Join(dir, "tls.key"), keyPEM)
+	require.NoError(t, certs.load())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestTLSHotReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	certPEM, keyPEM := ca.issue(t, "server", time.Now().Add(time.Hour), x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+
+	certs, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile}, zap.NewNop())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, certs.watch(ctx))
+
+	renewed := time.Now().Add(48 * time.Hour).Truncate(time.Second) //nolint: mnd
+	certPEM, keyPEM = ca.issue(t, "server", renewed, x509.ExtKeyUsageServerAuth)
+
+	// the key is written first so the pair is consistent once the
+	// certificate is written
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, certFile, certPEM)
+
+	assert.Eventually(t, func() bool {
+		return certs.NotAfter().Equal(renewed)
+	}, 5*time.Second, 20*time.Millisecond) //nolint: mnd
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	certPEM, keyPEM := ca.issue(t, "server", time.Now().Add(time.Hour), x509.ExtKeyUsageServerAuth)
+	writeFile(t, filepath.Join(dir, "tls.crt"), certPEM)
+	writeFile(t, filepath.Join(dir, "tls.key"), keyPEM)
+	writeFile(t, filepath.Join(dir, "ca.crt"), ca.pem)
+
+	certs, err := newCertReloader(TLSConfig{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+		MinVersion:   tls.VersionTLS13,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	hs := Server{logger: zap.NewNop(), certs: certs}
+	srv := hs.newHTTPServer()
+
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.TLS = srv.TLSConfig
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+
+	get := func(tlscfg *tls.Config) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlscfg, ForceAttemptHTTP2: true}}
+		req, _ := http.NewRequestWithContext(context.TODO(), http.MethodGet, ts.URL+"/healthz", nil)
+
+		return client.Do(req)
+	}
+
+	// a client without a certificate is rejected
+	_, err = get(&tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS13})
+	require.Error(t, err)
+
+	// a client below the minimum version is rejected
+	_, err = get(&tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12}) //nolint: gosec
+	require.Error(t, err)
+
+	clientPEM, clientKeyPEM := ca.issue(t, "client", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	require.NoError(t, err)
+
+	resp, err := get(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}, MinVersion: tls.VersionTLS13})
+	require.NoError(t, err)
+
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the client configuration keeps the listener's protocols
+	assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+	assert.Equal(t, 2, resp.ProtoMajor)
+}